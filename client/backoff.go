@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Delays between two calls rejected by an overloaded server,
+// unless the server asks for a longer one
+const (
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// withBackoff makes the call again while the server is overloaded, at most maxRetries times.
+// The delay between two calls doubles each time, and is at least the one asked by the server.
+func (c *ClientGRPC) withBackoff(ctx context.Context, call func() error) (err error) {
+	backoff := initialBackoff
+	for retry := 0; ; retry++ {
+		err = call()
+		overloaded, ok := errors.Cause(err).(*OverloadedError)
+		if !ok || retry >= c.maxRetries {
+			return
+		}
+
+		// Spread the calls of the clients rejected together
+		delay := time.Duration(float64(backoff) * (0.5 + rand.Float64()))
+		if delay < overloaded.RetryAfter {
+			delay = overloaded.RetryAfter
+		}
+		c.logger.Warn().Msg(fmt.Sprintf("server is overloaded: trying again in %s (%d/%d)",
+			delay, retry+1, c.maxRetries))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
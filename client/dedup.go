@@ -0,0 +1,100 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkHash sends the hash of the f file to the server, and returns the id of
+// the job created if the server already has its text, or "" if it must be uploaded.
+// The file is uploaded anyway if the check fails, e.g. with a server not supporting it,
+// and the next files are not hashed anymore if the server has no cache.
+func (c *ClientGRPC) checkHash(ctx context.Context, f string, opts *messaging.ExtractionOptions) (uuid string) {
+	if atomic.LoadInt32(c.noHashCheck) != 0 {
+		return
+	}
+	hash, err := messaging.HashFile(f)
+	if err != nil {
+		return
+	}
+	hash.Options = opts
+
+	st, err := c.client.CheckHash(ctx, hash)
+	if code := status.Code(err); code == codes.FailedPrecondition || code == codes.Unimplemented {
+		c.logger.Info().Msg("server doesn't cache the texts: files are uploaded without checking their hash")
+		atomic.StoreInt32(c.noHashCheck, 1)
+	}
+	if err != nil || !st.Known {
+		return
+	}
+
+	return st.Uuid
+}
+
+// cachedText returns the response to the upload of the f file if the server
+// already has its text, or nil if the file must be uploaded, which it is as well
+// if the text can't be received. The metadata can't be known without the file.
+func (c *ClientGRPC) cachedText(ctx context.Context, f string, opts *messaging.ExtractionOptions) (status *messaging.TextAndStatus, err error) {
+	if opts == nil {
+		opts = &messaging.ExtractionOptions{}
+	}
+	if opts.IncludeMetadata {
+		return
+	}
+	uuid := c.checkHash(ctx, f, opts)
+	if uuid == "" {
+		return
+	}
+
+	text, err := c.receiveText(ctx, uuid)
+	if err != nil {
+		// The cached text may have expired meanwhile: the file is uploaded as usual
+		c.logger.Warn().Err(err).Msg(fmt.Sprintf("failed to get the cached text of file %s: uploading it", f))
+		return nil, nil
+	}
+	c.logger.Info().Msg(fmt.Sprintf("text of file %s comes from the server cache: upload skipped", f))
+
+	status = &messaging.TextAndStatus{
+		Message: "File received with success",
+		Code:    messaging.StatusCode_Ok,
+		Cached:  true,
+	}
+	if opts.Output == messaging.OutputMode_OutputPages {
+		status.Pages = messaging.SplitPages(text, opts.FirstPage)
+	} else {
+		status.Text = text
+	}
+
+	return
+}
+
+// receiveText waits for the job to finish and returns its text.
+func (c *ClientGRPC) receiveText(ctx context.Context, uuid string) (text []byte, err error) {
+	downloadStream, err := c.client.GetText(ctx, &messaging.Id{
+		Uuid: uuid,
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create download stream for job %s",
+			uuid)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = messaging.ReceiveContent(downloadStream, &buf)
+	if err != nil {
+		err = errors.Wrapf(typedError(err),
+			"failed to receive the text of job %s",
+			uuid)
+		return
+	}
+
+	return buf.Bytes(), nil
+}
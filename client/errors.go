@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ExtractionError describes a failure reported by the server.
+// It is embedded in the distinct error types below.
+type ExtractionError struct {
+	Code    codes.Code
+	Message string
+	// Exit code and standard error output of the failed process, if any
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExtractionError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("%s (%s): exit code %d: %s", e.Message, e.Code, e.ExitCode, e.Stderr)
+	}
+
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// InvalidPdfError is returned when the uploaded file is not a readable pdf.
+type InvalidPdfError struct{ *ExtractionError }
+
+// InvalidOptionsError is returned when the extraction options are refused.
+type InvalidOptionsError struct{ *ExtractionError }
+
+// EncryptedPdfError is returned when the pdf can't be read without a password.
+type EncryptedPdfError struct{ *ExtractionError }
+
+// TooLargeError is returned when the uploaded file exceeds the server limit.
+type TooLargeError struct{ *ExtractionError }
+
+// WorkerUnavailableError is returned when no worker could process the file.
+type WorkerUnavailableError struct{ *ExtractionError }
+
+// TimeoutError is returned when the processing took too long.
+type TimeoutError struct{ *ExtractionError }
+
+// OverloadedError is returned when the server can't accept more work for now.
+type OverloadedError struct {
+	*ExtractionError
+	// Delay after which the server asks to be called again
+	RetryAfter time.Duration
+}
+
+// CorruptedError is returned when a file or a text doesn't match its checksum.
+type CorruptedError struct{ *ExtractionError }
+
+// LimitExceededError is returned when processing the file exceeded a limit of the worker,
+// like its CPU time or memory.
+type LimitExceededError struct{ *ExtractionError }
+
+// InternalError is returned for any other failure of the server or of a worker.
+type InternalError struct{ *ExtractionError }
+
+// typedError returns the distinct error type matching the gRPC status behind err.
+// Errors without status are returned as is.
+func typedError(err error) error {
+	st, ok := status.FromError(errors.Cause(err))
+	if !ok || st.Code() == codes.OK {
+		return err
+	}
+
+	e := &ExtractionError{
+		Code:    st.Code(),
+		Message: st.Message(),
+	}
+	detail := messaging.ErrorDetailOf(err)
+	if detail == nil {
+		// The deadline of the client itself is exceeded
+		if st.Code() == codes.DeadlineExceeded {
+			return &TimeoutError{e}
+		}
+		return err
+	}
+	e.ExitCode = int(detail.ExitCode)
+	e.Stderr = detail.Stderr
+
+	switch detail.Kind {
+	case messaging.ErrorKind_ErrorInvalidPdf:
+		return &InvalidPdfError{e}
+	case messaging.ErrorKind_ErrorInvalidOptions:
+		return &InvalidOptionsError{e}
+	case messaging.ErrorKind_ErrorEncrypted:
+		return &EncryptedPdfError{e}
+	case messaging.ErrorKind_ErrorTooLarge:
+		return &TooLargeError{e}
+	case messaging.ErrorKind_ErrorWorkerUnavailable:
+		return &WorkerUnavailableError{e}
+	case messaging.ErrorKind_ErrorTimeout:
+		return &TimeoutError{e}
+	case messaging.ErrorKind_ErrorCorrupted:
+		return &CorruptedError{e}
+	case messaging.ErrorKind_ErrorLimitExceeded:
+		return &LimitExceededError{e}
+	case messaging.ErrorKind_ErrorOverloaded:
+		delay, _ := messaging.RetryDelayOf(err)
+		return &OverloadedError{e, delay}
+	}
+
+	return &InternalError{e}
+}
+
+// withTrailer completes an OverloadedError with the retry-after trailer of the call.
+func withTrailer(err error, trailer metadata.MD) error {
+	overloaded, ok := errors.Cause(err).(*OverloadedError)
+	if !ok {
+		return err
+	}
+	if delay, ok := messaging.ParseRetryAfter(trailer); ok {
+		overloaded.RetryAfter = delay
+	}
+
+	return err
+}
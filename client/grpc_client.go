@@ -2,6 +2,8 @@ package client
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -15,6 +17,9 @@ import (
 	"gitlab.com/gaydamakha/ter-grpc/messaging"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 
 	_ "google.golang.org/grpc/encoding/gzip"
 )
@@ -26,10 +31,17 @@ type ClientGRPC struct {
 	logger    zerolog.Logger
 	conn      *grpc.ClientConn
 	client    messaging.PdftotextServiceClient
+	admin     messaging.PdftotextAdminClient
 	chunkSize int
 	txtDir    string
 	nbCalls   int64
 	nbcmtx    *sync.RWMutex
+	// calls made again when the server is overloaded
+	maxRetries int
+	// priority of the uploaded jobs
+	priority messaging.Priority
+	// set once the server tells it doesn't cache the texts, accessed atomically
+	noHashCheck *int32
 }
 
 type ClientGRPCConfig struct {
@@ -38,6 +50,10 @@ type ClientGRPCConfig struct {
 	RootCertificate string
 	Compress        bool
 	TxtDir          string
+	// Number of times a call rejected by an overloaded server is made again
+	MaxRetries int
+	// Priority of the uploaded jobs in the queue of the server
+	Priority messaging.Priority
 }
 
 func NewClientGRPC(cfg ClientGRPCConfig) (c ClientGRPC, err error) {
@@ -104,14 +120,18 @@ func NewClientGRPC(cfg ClientGRPCConfig) (c ClientGRPC, err error) {
 	}
 
 	c.client = messaging.NewPdftotextServiceClient(c.conn)
+	c.admin = messaging.NewPdftotextAdminClient(c.conn)
 
 	c.nbCalls = 0
 	c.nbcmtx = &sync.RWMutex{}
+	c.noHashCheck = new(int32)
+	c.maxRetries = cfg.MaxRetries
+	c.priority = cfg.Priority
 
 	return
 }
 
-func (c *ClientGRPC) PdfToTextFile(ctx context.Context, f string) (err error) {
+func (c *ClientGRPC) PdfToTextFile(ctx context.Context, f string, opts *messaging.ExtractionOptions) (err error) {
 	var (
 		status *messaging.TextAndStatus
 	)
@@ -119,6 +139,59 @@ func (c *ClientGRPC) PdfToTextFile(ctx context.Context, f string) (err error) {
 	c.nbCalls++
 	i := strconv.Itoa(int(c.nbCalls))
 	c.nbcmtx.Unlock()
+
+	status, err = c.uploadPdfAndGetText(ctx, f, opts)
+	if err != nil {
+		return
+	}
+
+	fn := filepath.Base(f)
+	txtfn := c.txtDir + strings.TrimSuffix(fn, path.Ext(fn)) + i + ".txt"
+	err = ioutil.WriteFile(txtfn, status.Text, 0644)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create result file %s",
+			txtfn)
+		return
+	}
+
+	if status.Metadata != nil {
+		metafn := strings.TrimSuffix(txtfn, ".txt") + ".json"
+		err = writeMetadata(metafn, status.Metadata)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+//PdfToPages returns the text of the file split by page.
+func (c *ClientGRPC) PdfToPages(ctx context.Context, f string, opts *messaging.ExtractionOptions) (pages []*messaging.Page, err error) {
+	if opts == nil {
+		opts = &messaging.ExtractionOptions{}
+	}
+	opts = proto.Clone(opts).(*messaging.ExtractionOptions)
+	opts.Output = messaging.OutputMode_OutputPages
+
+	status, err := c.uploadPdfAndGetText(ctx, f, opts)
+	if err != nil {
+		return
+	}
+
+	return status.Pages, nil
+}
+
+func (c *ClientGRPC) uploadPdfAndGetText(
+	ctx context.Context,
+	f string,
+	opts *messaging.ExtractionOptions) (status *messaging.TextAndStatus, err error) {
+	// No need to upload a file the server already knows
+	status, err = c.cachedText(ctx, f, opts)
+	if status != nil || err != nil {
+		return
+	}
+
 	// Open a stream-based connection with the
 	// gRPC server
 	stream, err := c.client.UploadPdfAndGetText(ctx)
@@ -130,14 +203,23 @@ func (c *ClientGRPC) PdfToTextFile(ctx context.Context, f string) (err error) {
 	}
 	defer stream.CloseSend()
 
+	err = messaging.SendOptionsWithPriority(stream, opts, c.priority)
+	if err != nil {
+		return
+	}
+
 	err = messaging.SendFile(stream, c.chunkSize, f, false)
 	if err != nil {
+		// The server aborted the stream, its status tells why
+		if _, rerr := stream.CloseAndRecv(); rerr != nil {
+			err = typedError(rerr)
+		}
 		return
 	}
 
 	status, err = stream.CloseAndRecv()
 	if err != nil {
-		err = errors.Wrapf(err,
+		err = errors.Wrapf(typedError(err),
 			"failed to receive upstream status response")
 		return
 	}
@@ -148,34 +230,79 @@ func (c *ClientGRPC) PdfToTextFile(ctx context.Context, f string) (err error) {
 			status.Message)
 		return
 	}
+	if status.Cached {
+		c.logger.Info().Msg(fmt.Sprintf("text of file %s comes from the server cache", f))
+	}
+
+	// Nothing is written from a corrupted text
+	err = typedError(messaging.VerifyText(status))
+
+	return
+}
+
+func (c *ClientGRPC) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+//PdfToTextFileBi uploads the file, then waits for the resulting text and writes it into the text directory.
+func (c *ClientGRPC) PdfToTextFileBi(ctx context.Context, f string, opts *messaging.ExtractionOptions) (err error) {
+	c.nbcmtx.Lock()
+	c.nbCalls++
+	i := strconv.Itoa(int(c.nbCalls))
+	c.nbcmtx.Unlock()
+
+	uuid, err := c.UploadPdf(ctx, f, opts)
+	if err != nil {
+		return
+	}
 
 	fn := filepath.Base(f)
 	txtfn := c.txtDir + strings.TrimSuffix(fn, path.Ext(fn)) + i + ".txt"
-	err = ioutil.WriteFile(txtfn, status.Text, 0644)
+	//TODO: do something other if text dir is not given
+	err = c.FetchText(ctx, uuid, txtfn)
 	if err != nil {
 		err = errors.Wrapf(err,
-			"failed to create result file %s",
-			txtfn)
+			"failed to fetch the text of file %s",
+			f)
 		return
 	}
 
 	return
 }
 
-func (c *ClientGRPC) Close() {
-	if c.conn != nil {
-		c.conn.Close()
+//UploadPdf uploads the file to be processed and returns the id of the created job.
+//The upload is skipped if the server already has the text of the file, resumed
+//if the connection is lost, and made again later if the server is overloaded.
+func (c *ClientGRPC) UploadPdf(ctx context.Context, f string, opts *messaging.ExtractionOptions) (uuid string, err error) {
+	if uuid = c.checkHash(ctx, f, opts); uuid != "" {
+		return
 	}
+
+	offset, err := c.createUpload(ctx, f)
+	if err != nil {
+		return
+	}
+	err = c.withBackoff(ctx, func() (err error) {
+		uuid, err = c.resumeUpload(ctx, f, opts, offset)
+		return
+	})
+
+	return
 }
 
-func (c *ClientGRPC) PdfToTextFileBi(ctx context.Context, f string) (err error) {
+// uploadPdf sends the file from the offset, continuing the resumable upload
+// of the given id, or as a plain upload if the id is empty.
+func (c *ClientGRPC) uploadPdf(
+	ctx context.Context,
+	f string,
+	opts *messaging.ExtractionOptions,
+	uploadID string,
+	offset int64) (uuid string, err error) {
 	var (
 		status *messaging.IdAndStatus
 	)
-	c.nbcmtx.Lock()
-	c.nbCalls++
-	i := strconv.Itoa(int(c.nbCalls))
-	c.nbcmtx.Unlock()
 
 	// Open a stream-based connection with the
 	// gRPC server
@@ -188,14 +315,23 @@ func (c *ClientGRPC) PdfToTextFileBi(ctx context.Context, f string) (err error)
 	}
 	defer stream.CloseSend()
 
-	err = messaging.SendFile(stream, c.chunkSize, f, false)
+	err = messaging.SendUploadOptions(stream, opts, c.priority, uploadID)
 	if err != nil {
 		return
 	}
 
+	err = messaging.SendFileAt(stream, c.chunkSize, f, offset)
+	if err != nil {
+		// The server aborted the stream, its status tells why
+		if _, rerr := stream.CloseAndRecv(); rerr != nil {
+			err = withTrailer(typedError(rerr), stream.Trailer())
+		}
+		return
+	}
+
 	status, err = stream.CloseAndRecv()
 	if err != nil {
-		err = errors.Wrapf(err,
+		err = errors.Wrapf(withTrailer(typedError(err), stream.Trailer()),
 			"failed to receive upstream status response")
 		return
 	}
@@ -207,22 +343,240 @@ func (c *ClientGRPC) PdfToTextFileBi(ctx context.Context, f string) (err error)
 		return
 	}
 
+	return status.Uuid, nil
+}
+
+//GetStatus returns the current state of the job without waiting for it to finish.
+func (c *ClientGRPC) GetStatus(ctx context.Context, uuid string) (status *messaging.JobStatus, err error) {
+	status, err = c.client.GetStatus(ctx, &messaging.Id{
+		Uuid: uuid,
+	})
+	if err != nil {
+		err = errors.Wrapf(typedError(err),
+			"failed to get status of job %s",
+			uuid)
+		return
+	}
+
+	return
+}
+
+//ListWorkers returns the workers known by the server, their health and the queue length.
+func (c *ClientGRPC) ListWorkers(ctx context.Context) (list *messaging.WorkerList, err error) {
+	list, err = c.admin.ListWorkers(ctx, &messaging.ListWorkersRequest{})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to list workers")
+		return
+	}
+
+	return
+}
+
+//GetJobStats returns the number of jobs kept by the server and the size of their files.
+func (c *ClientGRPC) GetJobStats(ctx context.Context) (stats *messaging.JobStats, err error) {
+	stats, err = c.admin.GetJobStats(ctx, &messaging.JobStatsRequest{})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to get job stats")
+		return
+	}
+
+	return
+}
+
+//CheckHealth asks the server whether the service is serving.
+//An empty service name stands for the server as a whole.
+func (c *ClientGRPC) CheckHealth(ctx context.Context, service string) (status healthpb.HealthCheckResponse_ServingStatus, err error) {
+	res, err := healthpb.NewHealthClient(c.conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: service,
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to check health of service %q",
+			service)
+		return
+	}
+
+	return res.Status, nil
+}
+
+//FetchText waits for the job to finish and writes its text into the txtfn file.
+func (c *ClientGRPC) FetchText(ctx context.Context, uuid string, txtfn string) (err error) {
 	downloadStream, err := c.client.GetText(ctx, &messaging.Id{
-		Uuid: status.Uuid,
+		Uuid: uuid,
 	})
 	if err != nil {
 		err = errors.Wrapf(err,
-			"failed to create download stream for file %s",
-			f)
+			"failed to create download stream for job %s",
+			uuid)
 		return
 	}
 
+	txtfile, err := messaging.ReceiveFile(downloadStream, txtfn)
+	if err != nil {
+		// Don't leave a partial result behind
+		os.Remove(txtfn)
+		err = typedError(err)
+		return
+	}
+	txtfile.Close()
+
+	return
+}
+
+//CancelJob stops the processing of the job and returns its new state.
+func (c *ClientGRPC) CancelJob(ctx context.Context, uuid string) (status *messaging.JobStatus, err error) {
+	status, err = c.client.CancelJob(ctx, &messaging.Id{
+		Uuid: uuid,
+	})
+	if err != nil {
+		err = errors.Wrapf(typedError(err),
+			"failed to cancel job %s",
+			uuid)
+		return
+	}
+
+	return
+}
+
+//DeleteJob stops the job if needed and makes the server forget it and its files.
+func (c *ClientGRPC) DeleteJob(ctx context.Context, uuid string) (status *messaging.JobStatus, err error) {
+	status, err = c.client.DeleteJob(ctx, &messaging.Id{
+		Uuid: uuid,
+	})
+	if err != nil {
+		err = errors.Wrapf(typedError(err),
+			"failed to delete job %s",
+			uuid)
+		return
+	}
+
+	return
+}
+
+//ExtractText uploads the file and writes the text directly into the text directory while it is streamed back.
+//The upload is made again later if the server is overloaded.
+func (c *ClientGRPC) ExtractText(ctx context.Context, f string, opts *messaging.ExtractionOptions) (err error) {
+	c.nbcmtx.Lock()
+	c.nbCalls++
+	i := strconv.Itoa(int(c.nbCalls))
+	c.nbcmtx.Unlock()
+
 	fn := filepath.Base(f)
 	txtfn := c.txtDir + strings.TrimSuffix(fn, path.Ext(fn)) + i + ".txt"
-	//TODO: do something other if text dir is not given
-	txtfile, err := messaging.ReceiveFile(downloadStream, txtfn)
-	defer txtfile.Close()
+
+	return c.withBackoff(ctx, func() error {
+		return c.extractText(ctx, f, opts, txtfn)
+	})
+}
+
+func (c *ClientGRPC) extractText(ctx context.Context, f string, opts *messaging.ExtractionOptions, txtfn string) (err error) {
+	// Open a bi-directional stream with the
+	// gRPC server
+	stream, err := c.client.ExtractText(ctx)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create extraction stream for file %s",
+			f)
+		return
+	}
+
+	err = messaging.SendOptionsWithPriority(stream, opts, c.priority)
+	if err != nil {
+		return
+	}
+
+	err = messaging.SendFile(stream, c.chunkSize, f, false)
+	if err != nil {
+		// The server aborted the stream, its status tells why
+		if _, rerr := stream.Recv(); rerr != nil && rerr != io.EOF {
+			err = withTrailer(typedError(rerr), stream.Trailer())
+		}
+		return
+	}
+
+	err = stream.CloseSend()
 	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to close upload stream for file %s",
+			f)
+		return
+	}
+
+	txtfile, err := os.Create(txtfn)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create result file %s",
+			txtfn)
+		return
+	}
+
+	err = messaging.ReceiveText(stream, txtfile)
+	txtfile.Close()
+	if err != nil {
+		// Don't leave a partial result behind
+		os.Remove(txtfn)
+		err = withTrailer(typedError(err), stream.Trailer())
+		return
+	}
+
+	return
+}
+
+//GetMetadata returns the document information of the file.
+//The upload is made again later if the server is overloaded.
+func (c *ClientGRPC) GetMetadata(ctx context.Context, f string) (meta *messaging.PdfMetadata, err error) {
+	err = c.withBackoff(ctx, func() (err error) {
+		meta, err = c.getMetadata(ctx, f)
+		return
+	})
+
+	return
+}
+
+func (c *ClientGRPC) getMetadata(ctx context.Context, f string) (meta *messaging.PdfMetadata, err error) {
+	stream, err := c.client.GetMetadata(ctx)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create upload stream for file %s",
+			f)
+		return
+	}
+	defer stream.CloseSend()
+
+	err = messaging.SendFile(stream, c.chunkSize, f, false)
+	if err != nil {
+		// The server aborted the stream, its status tells why
+		if _, rerr := stream.CloseAndRecv(); rerr != nil {
+			err = withTrailer(typedError(rerr), stream.Trailer())
+		}
+		return
+	}
+
+	meta, err = stream.CloseAndRecv()
+	if err != nil {
+		err = errors.Wrapf(withTrailer(typedError(err), stream.Trailer()),
+			"failed to receive metadata")
+		return
+	}
+
+	return
+}
+
+func writeMetadata(metafn string, meta *messaging.PdfMetadata) (err error) {
+	content, err := protojson.MarshalOptions{Multiline: true}.Marshal(meta)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to encode metadata")
+		return
+	}
+
+	err = ioutil.WriteFile(metafn, content, 0644)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create metadata file %s",
+			metafn)
 		return
 	}
 
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// createUpload starts a resumable upload of the f file. It returns nil
+// if the server doesn't support them, so the file is uploaded at once.
+func (c *ClientGRPC) createUpload(ctx context.Context, f string) (offset *messaging.UploadOffset, err error) {
+	info, err := os.Stat(f)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to read file %s",
+			f)
+		return
+	}
+
+	offset, err = c.client.CreateUpload(ctx, &messaging.NewUpload{
+		Size: info.Size(),
+	})
+	if status.Code(err) == codes.Unimplemented {
+		return nil, nil
+	}
+	if err != nil {
+		err = errors.Wrapf(typedError(err),
+			"failed to create upload for file %s",
+			f)
+		return
+	}
+
+	return
+}
+
+// resumeUpload sends the f file from the offset of the upload. When the stream is interrupted,
+// the upload is resumed from the offset kept by the server, at most maxRetries times.
+// A nil offset means a plain upload.
+func (c *ClientGRPC) resumeUpload(
+	ctx context.Context,
+	f string,
+	opts *messaging.ExtractionOptions,
+	offset *messaging.UploadOffset) (uuid string, err error) {
+	if offset == nil {
+		return c.uploadPdf(ctx, f, opts, "", 0)
+	}
+
+	backoff := initialBackoff
+	for retry := 0; ; retry++ {
+		uuid, err = c.uploadPdf(ctx, f, opts, offset.UploadId, offset.Offset)
+		if err == nil || !interrupted(err) || retry >= c.maxRetries {
+			return
+		}
+
+		c.logger.Warn().Msg(fmt.Sprintf("upload of file %s is interrupted: resuming in %s (%d/%d)",
+			f, backoff, retry+1, c.maxRetries))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		// Until the server answers, the chunks it already has are sent again
+		next, qerr := c.client.QueryUploadOffset(ctx, &messaging.UploadId{
+			Id: offset.UploadId,
+		})
+		switch {
+		case qerr == nil:
+			offset = next
+		case status.Code(qerr) == codes.NotFound:
+			// The partial file is lost, the upload must start over
+			return
+		}
+	}
+}
+
+// interrupted tells whether the upload failed because the stream was broken,
+// and not because the server refused it.
+func interrupted(err error) bool {
+	if messaging.ErrorDetailOf(err) != nil {
+		return false
+	}
+
+	switch status.Code(errors.Cause(err)) {
+	case codes.Unavailable, codes.Aborted:
+		return true
+	}
+
+	return false
+}
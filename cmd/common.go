@@ -3,8 +3,39 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
 )
 
+// sandboxFlags limit the processes reading the uploaded files, on the workers and on the server
+var sandboxFlags = []cli.Flag{
+	&cli.DurationFlag{
+		Name:  "cpu-limit",
+		Usage: "CPU time after which an extractor or pdfinfo process is killed, 0 for no limit",
+		Value: 2 * time.Minute,
+	},
+	&cli.Int64Flag{
+		Name:  "memory-limit",
+		Usage: "address space of an extractor or pdfinfo process in bytes, 0 for no limit",
+		Value: 2 << 30,
+	},
+	&cli.Int64Flag{
+		Name:  "file-size-limit",
+		Usage: "maximum size of the texts and the files written by the extractors in bytes, 0 for no limit",
+		Value: 512 << 20,
+	},
+	&cli.IntFlag{
+		Name:  "open-files-limit",
+		Usage: "number of files an extractor or pdfinfo process can open at once, 0 for no limit",
+		Value: 256,
+	},
+	&cli.StringFlag{
+		Name:  "run-as",
+		Usage: "unprivileged user running the extractor and pdfinfo processes, by name or uid[:gid], empty to keep the current user",
+	},
+}
+
 func must(err error) {
 	if err == nil {
 		return
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/urfave/cli/v2"
+	"gitlab.com/gaydamakha/ter-grpc/client"
+)
+
+var Fetch = cli.Command{
+	Name:   "fetch",
+	Usage:  "waits for a job to finish and downloads its text",
+	Action: fetchAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Value: "localhost:1313",
+			Usage: "address of the server to connect to",
+		},
+		&cli.StringFlag{
+			Name:  "id",
+			Usage: "id of the job",
+		},
+		&cli.StringFlag{
+			Name:  "txt-file",
+			Usage: "path of the file to store the text",
+		},
+		&cli.IntFlag{
+			Name:  "chunk-size",
+			Usage: "size of the chunk messages",
+			Value: (1 << 12),
+		},
+		&cli.StringFlag{
+			Name:  "root-certificate",
+			Usage: "path of a certificate to add to the root CAs",
+		},
+		&cli.BoolFlag{
+			Name:  "compress",
+			Usage: "whether or not to enable payload compression",
+		},
+	},
+}
+
+func fetchAction(c *cli.Context) (err error) {
+	var (
+		address         = c.String("address")
+		id              = c.String("id")
+		txtfn           = c.String("txt-file")
+		chunkSize       = c.Int("chunk-size")
+		rootCertificate = c.String("root-certificate")
+		compress        = c.Bool("compress")
+		clt             *client.ClientGRPC
+	)
+
+	if id == "" {
+		must(errors.New("id must be set"))
+	}
+
+	if txtfn == "" {
+		txtfn = id + ".txt"
+	}
+
+	grpcClient, err := client.NewClientGRPC(client.ClientGRPCConfig{
+		Address:         address,
+		RootCertificate: rootCertificate,
+		Compress:        compress,
+		ChunkSize:       chunkSize,
+	})
+	must(err)
+	clt = &grpcClient
+	defer clt.Close()
+
+	err = clt.FetchText(context.Background(), id, txtfn)
+	must(err)
+
+	return
+}
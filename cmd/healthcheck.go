@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gitlab.com/gaydamakha/ter-grpc/client"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var HealthCheck = cli.Command{
+	Name:   "healthcheck",
+	Usage:  "checks the health of a server or a worker, exits with 1 if it is not serving",
+	Action: healthCheckAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Value: "localhost:1313",
+			Usage: "address of the server or worker to connect to",
+		},
+		&cli.StringFlag{
+			Name:  "service",
+			Usage: "name of the service to check, empty for the whole process",
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Value: 5 * time.Second,
+			Usage: "maximum duration of the check",
+		},
+		&cli.StringFlag{
+			Name:  "root-certificate",
+			Usage: "path of a certificate to add to the root CAs",
+		},
+	},
+}
+
+func healthCheckAction(c *cli.Context) (err error) {
+	var (
+		address         = c.String("address")
+		service         = c.String("service")
+		timeout         = c.Duration("timeout")
+		rootCertificate = c.String("root-certificate")
+		clt             *client.ClientGRPC
+	)
+
+	grpcClient, err := client.NewClientGRPC(client.ClientGRPCConfig{
+		Address:         address,
+		RootCertificate: rootCertificate,
+		ChunkSize:       (1 << 12),
+	})
+	must(err)
+	clt = &grpcClient
+	defer clt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	status, err := clt.CheckHealth(ctx, service)
+	must(err)
+
+	fmt.Println(status)
+	if status != healthpb.HealthCheckResponse_SERVING {
+		os.Exit(1)
+	}
+
+	return
+}
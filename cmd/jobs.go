@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/gaydamakha/ter-grpc/client"
+)
+
+var Jobs = cli.Command{
+	Name:   "jobs",
+	Usage:  "shows the jobs kept by a server and the disk space of their files",
+	Action: jobsAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Value: "localhost:1313",
+			Usage: "address of the server to connect to",
+		},
+		&cli.StringFlag{
+			Name:  "root-certificate",
+			Usage: "path of a certificate to add to the root CAs",
+		},
+	},
+}
+
+func jobsAction(c *cli.Context) (err error) {
+	var (
+		address         = c.String("address")
+		rootCertificate = c.String("root-certificate")
+		clt             *client.ClientGRPC
+	)
+
+	grpcClient, err := client.NewClientGRPC(client.ClientGRPCConfig{
+		Address:         address,
+		RootCertificate: rootCertificate,
+		ChunkSize:       (1 << 12),
+	})
+	must(err)
+	clt = &grpcClient
+	defer clt.Close()
+
+	stats, err := clt.GetJobStats(context.Background())
+	must(err)
+
+	states := make([]string, 0, len(stats.Jobs))
+	for state := range stats.Jobs {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	total := int64(0)
+	for _, state := range states {
+		fmt.Printf("%s: %d\n", state, stats.Jobs[state])
+		total += stats.Jobs[state]
+	}
+	fmt.Printf("%d jobs kept, %d bytes of files, %d bytes of cached texts\n", total, stats.Bytes, stats.CacheBytes)
+	fmt.Printf("%d results expired, %d jobs forgotten since the start\n", stats.Expired, stats.Forgotten)
+
+	resultTTL, _ := ptypes.Duration(stats.ResultTtl)
+	failureTTL, _ := ptypes.Duration(stats.FailureTtl)
+	expiredTTL, _ := ptypes.Duration(stats.ExpiredTtl)
+	fmt.Printf("texts kept %s, failures %s, expired jobs %s\n", resultTTL, failureTTL, expiredTTL)
+
+	return
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"gitlab.com/gaydamakha/ter-grpc/client"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var Metadata = cli.Command{
+	Name:   "metadata",
+	Usage:  "prints the document information of a pdf file",
+	Action: metadataAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Value: "localhost:1313",
+			Usage: "address of the server to connect to",
+		},
+		&cli.IntFlag{
+			Name:  "chunk-size",
+			Usage: "size of the chunk messages",
+			Value: (1 << 12),
+		},
+		&cli.StringFlag{
+			Name:  "file",
+			Usage: "file to inspect",
+		},
+		&cli.StringFlag{
+			Name:  "root-certificate",
+			Usage: "path of a certificate to add to the root CAs",
+		},
+		&cli.BoolFlag{
+			Name:  "compress",
+			Usage: "whether or not to enable payload compression",
+		},
+		&cli.IntFlag{
+			Name:  "max-retries",
+			Usage: "number of times an upload rejected by an overloaded server is made again",
+			Value: 5,
+		},
+	},
+}
+
+func metadataAction(c *cli.Context) (err error) {
+	var (
+		address         = c.String("address")
+		chunkSize       = c.Int("chunk-size")
+		file            = c.String("file")
+		rootCertificate = c.String("root-certificate")
+		compress        = c.Bool("compress")
+		maxRetries      = c.Int("max-retries")
+		clt             *client.ClientGRPC
+	)
+
+	if file == "" {
+		must(errors.New("file must be set"))
+	}
+
+	grpcClient, err := client.NewClientGRPC(client.ClientGRPCConfig{
+		Address:         address,
+		RootCertificate: rootCertificate,
+		Compress:        compress,
+		ChunkSize:       chunkSize,
+		MaxRetries:      maxRetries,
+	})
+	must(err)
+	clt = &grpcClient
+	defer clt.Close()
+
+	meta, err := clt.GetMetadata(context.Background(), file)
+	must(err)
+
+	content, err := protojson.MarshalOptions{Multiline: true}.Marshal(meta)
+	must(err)
+	fmt.Println(string(content))
+
+	return
+}
@@ -1,18 +1,32 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/urfave/cli/v2"
 	"gitlab.com/gaydamakha/ter-grpc/client"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
 )
 
+// Priorities accepted by --priority
+var priorities = map[string]messaging.Priority{
+	"interactive": messaging.Priority_PriorityInteractive,
+	"normal":      messaging.Priority_PriorityNormal,
+	"batch":       messaging.Priority_PriorityBatch,
+}
+
 var PdfToText = cli.Command{
 	Name:   "pdftotext",
 	Usage:  "extracts text from pdf file",
@@ -54,11 +68,73 @@ var PdfToText = cli.Command{
 			Name:  "bidirectional",
 			Usage: "whether or not to enable bidirectional communication",
 		},
+		&cli.BoolFlag{
+			Name:  "metadata",
+			Usage: "whether or not to store the document information in a json file next to the text",
+		},
+		&cli.StringFlag{
+			Name:  "pages",
+			Usage: "write the text split by page, as one file per page (files) or as JSON Lines (jsonl)",
+		},
+		&cli.BoolFlag{
+			Name:  "stream",
+			Usage: "whether or not to receive the text as a stream while it is extracted",
+		},
+		&cli.BoolFlag{
+			Name:  "detach",
+			Usage: "whether or not to only upload the file and print the job id (with bidirectional)",
+		},
+		&cli.StringFlag{
+			Name:  "priority",
+			Usage: "priority of the job in the queue of the server: interactive, normal or batch",
+			Value: "normal",
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "maximum duration of each request, processing by the server and the worker included, 0 for none",
+		},
+		&cli.IntFlag{
+			Name:  "max-retries",
+			Usage: "number of times an upload rejected by an overloaded server is made again",
+			Value: 5,
+		},
 		&cli.IntFlag{
 			Name:  "iters",
 			Usage: "number of times to transform the file (testing option)",
 			Value: 1,
 		},
+		&cli.IntFlag{
+			Name:  "first-page",
+			Usage: "first page to convert",
+		},
+		&cli.IntFlag{
+			Name:  "last-page",
+			Usage: "last page to convert",
+		},
+		&cli.BoolFlag{
+			Name:  "layout",
+			Usage: "whether or not to maintain original physical layout",
+		},
+		&cli.BoolFlag{
+			Name:  "raw",
+			Usage: "whether or not to keep strings in content stream order",
+		},
+		&cli.StringFlag{
+			Name:  "encoding",
+			Usage: "output text encoding name (UTF-8, Latin1, ASCII7, Symbol, ZapfDingbats, UCS-2)",
+		},
+		&cli.StringFlag{
+			Name:  "eol",
+			Usage: "output end-of-line convention (unix, dos, mac)",
+		},
+		&cli.BoolFlag{
+			Name:  "no-page-breaks",
+			Usage: "whether or not to omit page breaks between pages",
+		},
+		&cli.StringFlag{
+			Name:  "extractor",
+			Usage: "backend extracting the text (pdftotext, mutool, go), the default one of the worker if empty",
+		},
 	},
 }
 
@@ -69,10 +145,17 @@ func pdftotextAction(c *cli.Context) (err error) {
 		file            = c.String("file")
 		rootCertificate = c.String("root-certificate")
 		compress        = c.Bool("compress")
+		maxRetries      = c.Int("max-retries")
+		priority        = c.String("priority")
+		timeout         = c.Duration("timeout")
 		iters           = c.Int("iters")
 		txtDir          = c.String("txt-dir")
 		resultfn        = c.String("result-fn")
 		bi              = c.Bool("bidirectional")
+		detach          = c.Bool("detach")
+		streamed        = c.Bool("stream")
+		pages           = c.String("pages")
+		opts            *messaging.ExtractionOptions
 		stats           client.Stats
 		clt             *client.ClientGRPC
 		errg            *errgroup.Group
@@ -88,30 +171,110 @@ func pdftotextAction(c *cli.Context) (err error) {
 		must(errors.New("file must be set"))
 	}
 
+	opts = &messaging.ExtractionOptions{
+		FirstPage:       int32(c.Int("first-page")),
+		LastPage:        int32(c.Int("last-page")),
+		Layout:          c.Bool("layout"),
+		Raw:             c.Bool("raw"),
+		Encoding:        c.String("encoding"),
+		Eol:             c.String("eol"),
+		NoPageBreaks:    c.Bool("no-page-breaks"),
+		IncludeMetadata: c.Bool("metadata"),
+		Extractor:       c.String("extractor"),
+	}
+	if pages != "" {
+		if pages != "files" && pages != "jsonl" {
+			must(errors.New("pages must be either files or jsonl"))
+		}
+		if bi || streamed {
+			must(errors.New("pages can't be used with bidirectional or stream"))
+		}
+		opts.Output = messaging.OutputMode_OutputPages
+	}
+	if opts.IncludeMetadata && (bi || streamed || pages != "") {
+		must(errors.New("metadata can't be used with bidirectional, stream or pages"))
+	}
+	must(messaging.ValidateOptions(opts))
+	jobPriority, ok := priorities[priority]
+	if !ok {
+		must(errors.New("priority must be either interactive, normal or batch"))
+	}
+
 	grpcClient, err := client.NewClientGRPC(client.ClientGRPCConfig{
 		Address:         address,
 		RootCertificate: rootCertificate,
 		Compress:        compress,
 		ChunkSize:       chunkSize,
+		MaxRetries:      maxRetries,
+		Priority:        jobPriority,
 		TxtDir:          txtDir,
 	})
 	must(err)
 	clt = &grpcClient
 	defer clt.Close()
 
+	// The deadline of a request is given to the server, which gives it to the worker
+	requestContext := func() (context.Context, context.CancelFunc) {
+		if timeout == 0 {
+			return context.WithCancel(context.Background())
+		}
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
 	// Here the "iters" goroutines are launched to simulate a simultaneous connection of multiple clients
 	stats.StartedAt = time.Now()
-	if bi {
+	if bi && detach {
+		// The ids are printed so the text can be fetched later
+		for i := 1; i <= iters; i++ {
+			errg.Go(func() error {
+				ctx, cancel := requestContext()
+				defer cancel()
+				uuid, err := clt.UploadPdf(ctx, file, opts)
+				if err == nil {
+					fmt.Println(uuid)
+				}
+				return err
+			})
+		}
+	} else if pages != "" {
+		for i := 1; i <= iters; i++ {
+			i := i
+			errg.Go(func() error {
+				ctx, cancel := requestContext()
+				defer cancel()
+				res, err := clt.PdfToPages(ctx, file, opts)
+				if err != nil {
+					return err
+				}
+				if pages == "jsonl" {
+					return writePagesJSONL(res, txtDir, file, i)
+				}
+				return writePageFiles(res, txtDir, file, i)
+			})
+		}
+	} else if streamed {
+		for i := 1; i <= iters; i++ {
+			errg.Go(func() error {
+				ctx, cancel := requestContext()
+				defer cancel()
+				return clt.ExtractText(ctx, file, opts)
+			})
+		}
+	} else if bi {
 		// The file will be processed by some of the worker
 		for i := 1; i <= iters; i++ {
 			errg.Go(func() error {
-				return clt.PdfToTextFileBi(context.Background(), file)
+				ctx, cancel := requestContext()
+				defer cancel()
+				return clt.PdfToTextFileBi(ctx, file, opts)
 			})
 		}
 	} else {
 		for i := 1; i <= iters; i++ {
 			errg.Go(func() error {
-				return clt.PdfToTextFile(context.Background(), file)
+				ctx, cancel := requestContext()
+				defer cancel()
+				return clt.PdfToTextFile(ctx, file, opts)
 			})
 		}
 	}
@@ -131,3 +294,41 @@ func pdftotextAction(c *cli.Context) (err error) {
 
 	return
 }
+
+// writePageFiles writes each page into its own text file.
+func writePageFiles(pages []*messaging.Page, txtDir string, f string, i int) (err error) {
+	fn := filepath.Base(f)
+	prefix := txtDir + strings.TrimSuffix(fn, path.Ext(fn)) + strconv.Itoa(i)
+	for _, page := range pages {
+		txtfn := fmt.Sprintf("%s-page%d.txt", prefix, page.Number)
+		err = ioutil.WriteFile(txtfn, page.Text, 0644)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// writePagesJSONL writes the pages into a JSON Lines file, one page per line.
+func writePagesJSONL(pages []*messaging.Page, txtDir string, f string, i int) (err error) {
+	var (
+		buf bytes.Buffer
+		enc = json.NewEncoder(&buf)
+	)
+
+	for _, page := range pages {
+		err = enc.Encode(struct {
+			Page int32  `json:"page"`
+			Text string `json:"text"`
+		}{page.Number, string(page.Text)})
+		if err != nil {
+			return
+		}
+	}
+
+	fn := filepath.Base(f)
+	jsonfn := txtDir + strings.TrimSuffix(fn, path.Ext(fn)) + strconv.Itoa(i) + ".jsonl"
+
+	return ioutil.WriteFile(jsonfn, buf.Bytes(), 0644)
+}
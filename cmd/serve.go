@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	"gitlab.com/gaydamakha/ter-grpc/server"
@@ -11,11 +12,10 @@ var Serve = cli.Command{
 	Name:   "serve",
 	Usage:  "initiates a gRPC server",
 	Action: serveAction,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
-			Name:     "workers",
-			Usage:    "IP addresses of workers",
-			Required: true,
+			Name:  "workers",
+			Usage: "IP addresses of workers, each one optionally followed by @weight, more can register later",
 		},
 		&cli.IntFlag{
 			Name:  "port",
@@ -39,7 +39,103 @@ var Serve = cli.Command{
 			Name:  "compress",
 			Usage: "whether or not to enable payload compression",
 		},
-	},
+		&cli.Int64Flag{
+			Name:  "max-file-size",
+			Usage: "maximum size of uploaded files in bytes, 0 for no limit",
+		},
+		&cli.DurationFlag{
+			Name:  "health-interval",
+			Usage: "delay between two checks of the workers health",
+			Value: 5 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "unhealthy-threshold",
+			Usage: "number of consecutive failed health checks before a worker stops receiving jobs",
+			Value: 3,
+		},
+		&cli.StringFlag{
+			Name:  "scheduler",
+			Usage: "how workers are picked: round-robin, least-outstanding, weighted-round-robin or p2c",
+			Value: server.SchedulerRoundRobin,
+		},
+		&cli.IntFlag{
+			Name:  "max-attempts",
+			Usage: "number of workers a job is dispatched to before failing, 1 for no retry",
+			Value: 3,
+		},
+		&cli.DurationFlag{
+			Name:  "retry-backoff",
+			Usage: "delay before dispatching a failed job to another worker, doubled at each retry",
+			Value: 200 * time.Millisecond,
+		},
+		&cli.DurationFlag{
+			Name:  "max-retry-backoff",
+			Usage: "maximum delay between two dispatches of a job",
+			Value: 5 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:  "heartbeat-timeout",
+			Usage: "delay after which a registered worker without heartbeat is dropped",
+			Value: 15 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:    "registration-token",
+			Usage:   "token the workers must give to register, registration being refused without one",
+			EnvVars: []string{"PDFTOTEXT_REGISTRATION_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:  "journal",
+			Usage: "file recording the jobs so they survive restarts, empty to keep them in memory only",
+		},
+		&cli.IntFlag{
+			Name:  "queue-size",
+			Usage: "maximum number of jobs of each priority waiting for a worker, more uploads are rejected",
+			Value: 100,
+		},
+		&cli.DurationFlag{
+			Name:  "priority-aging",
+			Usage: "waiting time after which a queued job gets the priority of the level above",
+			Value: 30 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "worker-concurrency",
+			Usage: "requests given at most at once to each worker of --workers",
+			Value: 4,
+		},
+		&cli.DurationFlag{
+			Name:  "retry-after",
+			Usage: "delay after which rejected clients are told to try again",
+			Value: time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "split-min-pages",
+			Usage: "number of pages from which a document is split across the workers, counted by pdfinfo on the server, 0 to never split",
+		},
+		&cli.Int64Flag{
+			Name:  "cache-size",
+			Usage: "disk space of the cache of the extracted texts, in bytes, 0 to disable it",
+			Value: 1 << 30,
+		},
+		&cli.DurationFlag{
+			Name:  "max-processing-time",
+			Usage: "maximum time between the upload of a request and its result, shortened by the deadline of the client; the max-processing-time of the workers must not be shorter",
+			Value: 10 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "result-ttl",
+			Usage: "duration during which the text of a done job can be fetched",
+			Value: 10 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "failure-ttl",
+			Usage: "duration during which the failure of a failed or cancelled job can be fetched, --result-ttl if 0",
+		},
+		&cli.DurationFlag{
+			Name:  "expired-ttl",
+			Usage: "duration during which the state of an expired job is still given, before the job is forgotten",
+			Value: time.Hour,
+		},
+	}, sandboxFlags...),
 }
 
 func serveAction(c *cli.Context) (err error) {
@@ -50,16 +146,66 @@ func serveAction(c *cli.Context) (err error) {
 		compress    = c.Bool("compress")
 		chunkSize   = c.Int("chunk-size")
 		adWorkers   = strings.Fields(c.String("workers"))
+		maxFileSize = c.Int64("max-file-size")
+		interval    = c.Duration("health-interval")
+		threshold   = c.Int("unhealthy-threshold")
+		scheduler   = c.String("scheduler")
+		maxAttempts = c.Int("max-attempts")
+		backoff     = c.Duration("retry-backoff")
+		maxBackoff  = c.Duration("max-retry-backoff")
+		hbTimeout   = c.Duration("heartbeat-timeout")
+		regToken    = c.String("registration-token")
+		journal     = c.String("journal")
+		queueSize   = c.Int("queue-size")
+		aging       = c.Duration("priority-aging")
+		concurrency = c.Int("worker-concurrency")
+		retryAfter  = c.Duration("retry-after")
+		splitPages  = c.Int("split-min-pages")
+		cacheSize   = c.Int64("cache-size")
+		maxTime     = c.Duration("max-processing-time")
+		resultTTL   = c.Duration("result-ttl")
+		failureTTL  = c.Duration("failure-ttl")
+		expiredTTL  = c.Duration("expired-ttl")
+		cpuLimit    = c.Duration("cpu-limit")
+		memoryLimit = c.Int64("memory-limit")
+		fileLimit   = c.Int64("file-size-limit")
+		filesLimit  = c.Int("open-files-limit")
+		runAs       = c.String("run-as")
 		srv         *server.ServerGRPC
 	)
 
 	grpcServer, err := server.NewServerGRPC(server.ServerGRPCConfig{
-		Port:        port,
-		Certificate: certificate,
-		Key:         key,
-		ChunkSize:   chunkSize,
-		AdWorkers:   adWorkers,
-		Compress:    compress,
+		Port:               port,
+		Certificate:        certificate,
+		Key:                key,
+		ChunkSize:          chunkSize,
+		AdWorkers:          adWorkers,
+		Scheduler:          scheduler,
+		Compress:           compress,
+		MaxFileSize:        maxFileSize,
+		HealthInterval:     interval,
+		UnhealthyThreshold: threshold,
+		MaxAttempts:        maxAttempts,
+		RetryBackoff:       backoff,
+		MaxRetryBackoff:    maxBackoff,
+		HeartbeatTimeout:   hbTimeout,
+		RegistrationToken:  regToken,
+		JobJournal:         journal,
+		QueueSize:          queueSize,
+		PriorityAging:      aging,
+		WorkerConcurrency:  concurrency,
+		RetryAfter:         retryAfter,
+		SplitMinPages:      splitPages,
+		CacheSize:          cacheSize,
+		MaxProcessingTime:  maxTime,
+		ResultTTL:          resultTTL,
+		FailureTTL:         failureTTL,
+		ExpiredTTL:         expiredTTL,
+		CPULimit:           cpuLimit,
+		MemoryLimit:        memoryLimit,
+		FileSizeLimit:      fileLimit,
+		OpenFilesLimit:     filesLimit,
+		RunAs:              runAs,
 	})
 	must(err)
 	srv = &grpcServer
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"gitlab.com/gaydamakha/ter-grpc/client"
+)
+
+var Status = cli.Command{
+	Name:   "status",
+	Usage:  "prints the state of a job created by an upload",
+	Action: statusAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Value: "localhost:1313",
+			Usage: "address of the server to connect to",
+		},
+		&cli.StringFlag{
+			Name:  "id",
+			Usage: "id of the job",
+		},
+		&cli.StringFlag{
+			Name:  "root-certificate",
+			Usage: "path of a certificate to add to the root CAs",
+		},
+	},
+}
+
+func statusAction(c *cli.Context) (err error) {
+	var (
+		address         = c.String("address")
+		id              = c.String("id")
+		rootCertificate = c.String("root-certificate")
+		clt             *client.ClientGRPC
+	)
+
+	if id == "" {
+		must(errors.New("id must be set"))
+	}
+
+	grpcClient, err := client.NewClientGRPC(client.ClientGRPCConfig{
+		Address:         address,
+		RootCertificate: rootCertificate,
+		ChunkSize:       (1 << 12),
+	})
+	must(err)
+	clt = &grpcClient
+	defer clt.Close()
+
+	status, err := clt.GetStatus(context.Background(), id)
+	must(err)
+
+	fmt.Printf("%s %s: %s\n", status.Uuid, status.State, status.Message)
+	if status.Cached {
+		fmt.Println("text comes from the server cache")
+	}
+
+	return
+}
@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"errors"
+	"strings"
+	"time"
+
 	"github.com/urfave/cli/v2"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
 	"gitlab.com/gaydamakha/ter-grpc/worker"
 )
 
@@ -9,12 +14,64 @@ var WorkerServe = cli.Command{
 	Name:   "worker-serve",
 	Usage:  "initiates a gRPC server",
 	Action: workerServeAction,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.IntFlag{
 			Name:  "port",
 			Usage: "port to bind to",
 			Value: 1313,
 		},
+		&cli.IntFlag{
+			Name:  "chunk-size",
+			Usage: "size of the chunk messages",
+			Value: (1 << 12),
+		},
+		&cli.StringFlag{
+			Name:  "tmp-dir",
+			Usage: "directory to store the files being processed",
+			Value: "/tmp/pdftotext/worker/",
+		},
+		&cli.DurationFlag{
+			Name:  "health-interval",
+			Usage: "delay between two checks of the worker health",
+			Value: 5 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "register-with",
+			Usage: "address of a server to register with, instead of being given to the server at its startup",
+		},
+		&cli.StringFlag{
+			Name:    "registration-token",
+			Usage:   "token given to the server of --register-with, as set on the server",
+			EnvVars: []string{"PDFTOTEXT_REGISTRATION_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:  "advertise",
+			Usage: "address the server can reach the worker at (default: hostname and port)",
+		},
+		&cli.IntFlag{
+			Name:  "capacity",
+			Usage: "number of requests the worker can process at once, announced to the server",
+			Value: 1,
+		},
+		&cli.StringFlag{
+			Name:  "labels",
+			Usage: "labels announced to the server, as key=value pairs separated by commas",
+		},
+		&cli.DurationFlag{
+			Name:  "heartbeat-interval",
+			Usage: "delay between two heartbeats sent to the server",
+			Value: 5 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:  "max-processing-time",
+			Usage: "time after which the extraction is stopped if the request has no earlier deadline, at least the max-processing-time of the server",
+			Value: 10 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:  "extractors",
+			Usage: "extractors the worker may run, separated by commas, the first available one being the default",
+			Value: strings.Join(extractor.Names(), ","),
+		},
 		&cli.StringFlag{
 			Name:  "key",
 			Usage: "path to TLS certificate",
@@ -23,21 +80,55 @@ var WorkerServe = cli.Command{
 			Name:  "certificate",
 			Usage: "path to TLS certificate",
 		},
-	},
+	}, sandboxFlags...),
 }
 
 func workerServeAction(c *cli.Context) (err error) {
 	var (
-		port        = c.Int("port")
-		key         = c.String("key")
-		certificate = c.String("certificate")
-		wrk         *worker.WorkerServerGRPC
+		port           = c.Int("port")
+		key            = c.String("key")
+		certificate    = c.String("certificate")
+		chunkSize      = c.Int("chunk-size")
+		tmpDir         = c.String("tmp-dir")
+		healthInterval = c.Duration("health-interval")
+		registerWith   = c.String("register-with")
+		regToken       = c.String("registration-token")
+		advertise      = c.String("advertise")
+		capacity       = c.Int("capacity")
+		heartbeat      = c.Duration("heartbeat-interval")
+		maxProcessing  = c.Duration("max-processing-time")
+		cpuLimit       = c.Duration("cpu-limit")
+		memoryLimit    = c.Int64("memory-limit")
+		fileSizeLimit  = c.Int64("file-size-limit")
+		openFilesLimit = c.Int("open-files-limit")
+		runAs          = c.String("run-as")
+		extractors     = strings.Split(c.String("extractors"), ",")
+		wrk            *worker.WorkerServerGRPC
 	)
 
+	labels, err := parseLabels(c.String("labels"))
+	must(err)
+
 	grpcWorkerServer, err := worker.NewWorkerServerGRPC(worker.WorkerServerGRPCConfig{
-		Port:        port,
-		Certificate: certificate,
-		Key:         key,
+		Port:              port,
+		Certificate:       certificate,
+		Key:               key,
+		ChunkSize:         chunkSize,
+		TmpDir:            tmpDir,
+		HealthInterval:    healthInterval,
+		RegisterWith:      registerWith,
+		RegistrationToken: regToken,
+		Advertise:         advertise,
+		Capacity:          capacity,
+		Labels:            labels,
+		HeartbeatInterval: heartbeat,
+		MaxProcessingTime: maxProcessing,
+		CPULimit:          cpuLimit,
+		MemoryLimit:       memoryLimit,
+		FileSizeLimit:     fileSizeLimit,
+		OpenFilesLimit:    openFilesLimit,
+		RunAs:             runAs,
+		Extractors:        extractors,
 	})
 	must(err)
 	wrk = &grpcWorkerServer
@@ -48,3 +139,21 @@ func workerServeAction(c *cli.Context) (err error) {
 
 	return
 }
+
+// parseLabels reads labels given as key=value pairs separated by commas.
+func parseLabels(s string) (labels map[string]string, err error) {
+	if s == "" {
+		return
+	}
+
+	labels = make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.New("labels must be given as key=value pairs separated by commas")
+		}
+		labels[kv[0]] = kv[1]
+	}
+
+	return
+}
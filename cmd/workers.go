@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/urfave/cli/v2"
+	"gitlab.com/gaydamakha/ter-grpc/client"
+)
+
+var Workers = cli.Command{
+	Name:   "workers",
+	Usage:  "lists the workers of a server and their health",
+	Action: workersAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Value: "localhost:1313",
+			Usage: "address of the server to connect to",
+		},
+		&cli.StringFlag{
+			Name:  "root-certificate",
+			Usage: "path of a certificate to add to the root CAs",
+		},
+	},
+}
+
+func workersAction(c *cli.Context) (err error) {
+	var (
+		address         = c.String("address")
+		rootCertificate = c.String("root-certificate")
+		clt             *client.ClientGRPC
+	)
+
+	grpcClient, err := client.NewClientGRPC(client.ClientGRPCConfig{
+		Address:         address,
+		RootCertificate: rootCertificate,
+		ChunkSize:       (1 << 12),
+	})
+	must(err)
+	clt = &grpcClient
+	defer clt.Close()
+
+	list, err := clt.ListWorkers(context.Background())
+	must(err)
+
+	fmt.Printf("%d/%d jobs waiting for a worker\n", list.QueueLength, list.QueueSize)
+
+	for _, w := range list.Workers {
+		state := "healthy"
+		if !w.Healthy {
+			state = "unhealthy"
+		}
+		lastCheck := "never"
+		if t, err := ptypes.Timestamp(w.LastCheck); err == nil {
+			lastCheck = t.Format("15:04:05")
+		}
+		fmt.Printf("%s %s, weight %d, %d in flight, %d failed checks in a row, last check %s",
+			w.Address, state, w.Weight, w.InFlight, w.ConsecutiveFailures, lastCheck)
+		if w.LastError != "" {
+			fmt.Printf(": %s", w.LastError)
+		}
+		if len(w.Extractors) > 0 {
+			fmt.Printf("\n  extractors %s", strings.Join(w.Extractors, ", "))
+		}
+		if w.Id != "" {
+			lastHeartbeat := "never"
+			if t, err := ptypes.Timestamp(w.LastHeartbeat); err == nil {
+				lastHeartbeat = t.Format("15:04:05")
+			}
+			fmt.Printf("\n  registered as %s, last heartbeat %s, labels %v", w.Id, lastHeartbeat, w.Labels)
+		}
+		fmt.Println()
+	}
+
+	return
+}
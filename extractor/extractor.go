@@ -0,0 +1,146 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// Names of the extractors, as given in the extraction options
+const (
+	Poppler = "pdftotext"
+	Mutool  = "mutool"
+	Go      = "go"
+)
+
+// Extractor extracts the text of pdf files.
+type Extractor interface {
+	// Name identifies the extractor in the extraction options
+	Name() string
+	// Available returns an error if the extractor can't run on this host
+	Available() error
+	// Check returns an ErrorInvalidOptions error if the extractor doesn't support the options
+	Check(opts *messaging.ExtractionOptions) error
+	// Extract writes the text of the fn pdf file into w. The processes it needs are run by
+	// run, and may write their temporary files next to fn.
+	Extract(ctx context.Context, run Runner, fn string, w io.Writer, opts *messaging.ExtractionOptions) error
+}
+
+// Runner runs the processes of the extractors.
+type Runner interface {
+	// Command returns the name process, killed once the ctx is done
+	Command(ctx context.Context, name string, args ...string) *exec.Cmd
+	// Error turns the failure of the name process run with ctx into a gRPC status error
+	Error(ctx context.Context, name string, err error, stderr []byte) error
+}
+
+// Extractors known by name, in order of preference
+var extractors = []Extractor{
+	poppler{},
+	mutool{},
+	goExtractor{},
+}
+
+// Names returns the names of the known extractors, in order of preference.
+func Names() (names []string) {
+	for _, e := range extractors {
+		names = append(names, e.Name())
+	}
+
+	return
+}
+
+// Lookup returns the extractor of the given name, or an ErrorInvalidOptions error if it is unknown.
+func Lookup(name string) (Extractor, error) {
+	for _, e := range extractors {
+		if e.Name() == name {
+			return e, nil
+		}
+	}
+
+	return nil, messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+		"extractor %s is unknown", name)
+}
+
+// Check returns an ErrorInvalidOptions error if the extractor asked by the options is unknown
+// or doesn't support them. The default extractor of a worker is only known by the worker.
+func Check(opts *messaging.ExtractionOptions) error {
+	if opts.Extractor == "" {
+		return nil
+	}
+	e, err := Lookup(opts.Extractor)
+	if err != nil {
+		return err
+	}
+
+	return e.Check(opts)
+}
+
+// ExtractReader is Extract for a pdf read from r. The pdf is written in a temporary file of the dir directory.
+func ExtractReader(ctx context.Context, e Extractor, run Runner, r io.Reader, dir string, w io.Writer, opts *messaging.ExtractionOptions) (err error) {
+	file, err := ioutil.TempFile(dir, "document*.pdf")
+	if err != nil {
+		return errors.Wrapf(err,
+			"failed to create temporary file in %s",
+			dir)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrapf(err,
+			"failed to write pdf into %s",
+			file.Name())
+	}
+
+	return e.Extract(ctx, run, file.Name(), w, opts)
+}
+
+// unsupported returns the error telling the options are not supported by the name extractor.
+func unsupported(name string, option string) error {
+	return messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+		"%s is not supported by the %s extractor", option, name)
+}
+
+// checkPlain returns an error for the options only pdftotext supports:
+// the other extractors write UTF-8 text in reading order.
+func checkPlain(name string, opts *messaging.ExtractionOptions) error {
+	switch {
+	case opts.Layout:
+		return unsupported(name, "layout mode")
+	case opts.Encoding != "" && opts.Encoding != "UTF-8":
+		return unsupported(name, "encoding "+opts.Encoding)
+	}
+
+	return nil
+}
+
+// writePage writes the text of a page as pdftotext does: the lines end with the end-of-line
+// convention of the options and the page with a form feed, unless page breaks are disabled.
+func writePage(w io.Writer, text []byte, opts *messaging.ExtractionOptions) (err error) {
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		text = append(text, '\n')
+	}
+	switch opts.Eol {
+	case "dos":
+		text = bytes.ReplaceAll(text, []byte("\n"), []byte("\r\n"))
+	case "mac":
+		text = bytes.ReplaceAll(text, []byte("\n"), []byte("\r"))
+	}
+	if !opts.NoPageBreaks {
+		text = append(text, '\f')
+	}
+
+	_, err = w.Write(text)
+
+	return
+}
@@ -0,0 +1,747 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// Depth of the forms drawn by forms beyond which they are ignored
+const maxFormDepth = 8
+
+// Size of the content streams interpreted for a document beyond which the extraction
+// is stopped, a form drawn several times counting each time
+const maxContentSize = 256 << 20
+
+// goExtractor reads the text of the pdf files itself, so it runs on any host. It only
+// knows the fonts with a ToUnicode map or a standard encoding, and lays out the text
+// in the order of the content streams, breaking the lines where the text moves up or down.
+type goExtractor struct{}
+
+func (goExtractor) Name() string {
+	return Go
+}
+
+func (goExtractor) Available() error {
+	return nil
+}
+
+// Check accepts the raw mode, the text being written in content stream order anyway.
+func (goExtractor) Check(opts *messaging.ExtractionOptions) error {
+	return checkPlain(Go, opts)
+}
+
+func (goExtractor) Extract(ctx context.Context, run Runner, fn string, w io.Writer, opts *messaging.ExtractionOptions) (err error) {
+	if err = (goExtractor{}).Check(opts); err != nil {
+		return
+	}
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return errors.Wrapf(err,
+			"can't read pdf file %s",
+			fn)
+	}
+
+	return extractText(ctx, data, w, opts)
+}
+
+// extractText writes the text of the pdf data into w. The data is untrusted: a document
+// breaking the parser in an unexpected way is reported as invalid rather than crashing the process.
+func extractText(ctx context.Context, data []byte, w io.Writer, opts *messaging.ExtractionOptions) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = messaging.NewError(messaging.ErrorKind_ErrorInvalidPdf,
+				"go extractor can't read the document: %v", r)
+		}
+	}()
+
+	doc, err := openPdf(data)
+	if err == errEncrypted {
+		return messaging.NewError(messaging.ErrorKind_ErrorEncrypted,
+			"encrypted documents are not supported by the go extractor")
+	}
+	if err != nil {
+		return messaging.NewError(messaging.ErrorKind_ErrorInvalidPdf,
+			"go extractor can't read the document: %s", err)
+	}
+
+	pages := doc.pages()
+	if len(pages) == 0 {
+		return messaging.NewError(messaging.ErrorKind_ErrorInvalidPdf,
+			"go extractor finds no page in the document")
+	}
+	first, last := int(opts.FirstPage), int(opts.LastPage)
+	if first == 0 {
+		first = 1
+	}
+	if last == 0 || last > len(pages) {
+		last = len(pages)
+	}
+	if first > last {
+		return messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+			"first page %d is after the last page %d of the document", first, last)
+	}
+
+	t := &textWriter{ctx: ctx, doc: doc, fonts: make(map[pdfRef]*pdfFont)}
+	for _, page := range pages[first-1 : last] {
+		if ctx.Err() != nil {
+			return messaging.CommandError(ctx, "go extractor", ctx.Err(), nil)
+		}
+		text := t.pageText(page)
+		if t.exhausted() {
+			return messaging.NewError(messaging.ErrorKind_ErrorLimitExceeded,
+				"document needs more work than the go extractor allows: more than %d bytes of streams or %d bytes of content",
+				maxDecodedSize, maxContentSize)
+		}
+		err = writePage(w, text, opts)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// pdfPage is a page with the resources it inherits.
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages returns the pages of the document in order.
+func (d *pdfDoc) pages() (pages []pdfPage) {
+	visited := make(map[pdfRef]bool)
+
+	var walk func(node interface{}, resources pdfDict, depth int)
+	walk = func(node interface{}, resources pdfDict, depth int) {
+		if depth >= maxNesting {
+			return
+		}
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref] {
+				return
+			}
+			visited[ref] = true
+		}
+		dict := d.dict(node)
+		if dict == nil {
+			return
+		}
+		if res := d.dict(dict["Resources"]); res != nil {
+			resources = res
+		}
+
+		kids := d.array(dict["Kids"])
+		if kids == nil && d.name(dict["Type"]) != "Pages" {
+			pages = append(pages, pdfPage{dict: dict, resources: resources})
+			return
+		}
+		for _, kid := range kids {
+			walk(kid, resources, depth+1)
+		}
+	}
+	walk(d.dict(d.root)["Pages"], nil, 0)
+
+	return
+}
+
+// textWriter writes the text shown by the content streams of the pages.
+type textWriter struct {
+	// the forms of a page stop being drawn once it is done
+	ctx   context.Context
+	doc   *pdfDoc
+	fonts map[pdfRef]*pdfFont
+	buf   bytes.Buffer
+	// state of the text being shown
+	font      *pdfFont
+	fontSize  float64
+	leading   float64
+	charSpace float64
+	wordSpace float64
+	// text matrix and text line matrix
+	tm, tlm [6]float64
+	// where the last text shown ends, to tell a new word or line from the same one
+	endX, endY float64
+	// size of the content interpreted so far
+	interpreted int
+}
+
+// exhausted tells whether the document went beyond the limits of the extractor.
+func (t *textWriter) exhausted() bool {
+	return t.interpreted > maxContentSize || t.doc.decodedSize > maxDecodedSize
+}
+
+var identity = [6]float64{1, 0, 0, 1, 0, 0}
+
+// pageText returns the text of the page.
+func (t *textWriter) pageText(page pdfPage) []byte {
+	t.buf.Reset()
+	t.font = nil
+
+	var content []byte
+	contents := t.doc.resolve(page.dict["Contents"])
+	if arr, ok := contents.(pdfArray); ok {
+		for _, c := range arr {
+			// The same stream may be listed many times
+			if len(content) > maxContentSize {
+				break
+			}
+			content = append(append(content, t.streamData(c)...), '\n')
+		}
+	} else {
+		content = t.streamData(contents)
+	}
+	t.run(content, page.resources, 0)
+
+	return append([]byte(nil), t.buf.Bytes()...)
+}
+
+// streamData returns the decoded data of the stream, nothing if it can't be decoded.
+func (t *textWriter) streamData(obj interface{}) []byte {
+	s := t.doc.stream(obj)
+	if s == nil {
+		return nil
+	}
+	data, err := t.doc.decode(s)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// run interprets the text operators of a content stream using the resources.
+func (t *textWriter) run(content []byte, resources pdfDict, depth int) {
+	t.interpreted += len(content)
+	if t.exhausted() || t.ctx.Err() != nil {
+		return
+	}
+	l := &lexer{data: content}
+	var operands []interface{}
+	for {
+		obj, err := l.object()
+		if err != nil {
+			return
+		}
+		op, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		numbers := make([]float64, len(operands))
+		for i, operand := range operands {
+			numbers[i], _ = operand.(float64)
+		}
+		switch {
+		case op == "BT":
+			t.tm, t.tlm = identity, identity
+		case op == "Tf" && len(operands) == 2:
+			name, _ := operands[0].(pdfName)
+			t.font = t.loadFont(resources, name)
+			t.fontSize = numbers[1]
+		case op == "TL" && len(operands) == 1:
+			t.leading = numbers[0]
+		case op == "Tc" && len(operands) == 1:
+			t.charSpace = numbers[0]
+		case op == "Tw" && len(operands) == 1:
+			t.wordSpace = numbers[0]
+		case op == "Td" && len(operands) == 2:
+			t.moveLine(numbers[0], numbers[1])
+		case op == "TD" && len(operands) == 2:
+			t.leading = -numbers[1]
+			t.moveLine(numbers[0], numbers[1])
+		case op == "Tm" && len(operands) == 6:
+			copy(t.tlm[:], numbers)
+			t.tm = t.tlm
+			t.moved()
+		case op == "T*":
+			t.nextLine()
+		case op == "Tj" && len(operands) == 1:
+			t.show(operands[0])
+		case op == "'" && len(operands) == 1:
+			t.nextLine()
+			t.show(operands[0])
+		case op == "\"" && len(operands) == 3:
+			t.wordSpace, t.charSpace = numbers[0], numbers[1]
+			t.nextLine()
+			t.show(operands[2])
+		case op == "TJ" && len(operands) == 1:
+			arr, _ := operands[0].(pdfArray)
+			for _, item := range arr {
+				if n, ok := item.(float64); ok {
+					t.kern(n)
+				} else {
+					t.show(item)
+				}
+			}
+		case op == "Do" && len(operands) == 1 && depth < maxFormDepth:
+			name, _ := operands[0].(pdfName)
+			form := t.doc.stream(t.doc.dict(resources["XObject"])[name])
+			if form != nil && t.doc.name(form.dict["Subtype"]) == "Form" {
+				formResources := t.doc.dict(form.dict["Resources"])
+				if formResources == nil {
+					formResources = resources
+				}
+				t.run(t.streamData(form), formResources, depth+1)
+			}
+		case op == "BI":
+			l.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+// skipInlineImage moves after the data of an inline image, which may look like anything.
+func (l *lexer) skipInlineImage() {
+	for {
+		obj, err := l.object()
+		if err != nil {
+			return
+		}
+		if obj == keyword("ID") {
+			break
+		}
+	}
+
+	for l.pos++; l.pos+2 <= len(l.data); l.pos++ {
+		if l.data[l.pos] == 'E' && l.data[l.pos+1] == 'I' && isSpace(l.data[l.pos-1]) &&
+			(l.pos+2 == len(l.data) || isSpace(l.data[l.pos+2])) {
+			l.pos += 2
+			return
+		}
+	}
+	l.pos = len(l.data)
+}
+
+// moveLine starts a new line at the tx, ty offset of the current one.
+func (t *textWriter) moveLine(tx, ty float64) {
+	t.tlm[4] += tx*t.tlm[0] + ty*t.tlm[2]
+	t.tlm[5] += tx*t.tlm[1] + ty*t.tlm[3]
+	t.tm = t.tlm
+	t.moved()
+}
+
+func (t *textWriter) nextLine() {
+	if t.leading == 0 {
+		t.newLine()
+	}
+	t.moveLine(0, -t.leading)
+}
+
+// scale returns the size of the current font on the page.
+func (t *textWriter) scale() float64 {
+	size := math.Abs(t.fontSize) * math.Hypot(t.tm[2], t.tm[3])
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// moved breaks the line if the text moved up or down, or separates
+// the words if the text moved forward by more than a narrow space.
+func (t *textWriter) moved() {
+	x, y := t.tm[4], t.tm[5]
+	switch {
+	case math.Abs(y-t.endY) > t.scale()/2:
+		t.newLine()
+	case x > t.endX+t.scale()/5:
+		t.space()
+	}
+	t.endX, t.endY = x, y
+}
+
+// kern moves the text back by n thousandths of the font size, a large move being a space.
+func (t *textWriter) kern(n float64) {
+	if n < -200 {
+		t.space()
+	}
+	t.advance(-n / 1000 * t.fontSize)
+}
+
+// advance moves the text forward by dx in text space.
+func (t *textWriter) advance(dx float64) {
+	t.tm[4] += dx * t.tm[0]
+	t.tm[5] += dx * t.tm[1]
+	t.endX, t.endY = t.tm[4], t.tm[5]
+}
+
+// show writes the text of the string with the current font, and moves after it.
+func (t *textWriter) show(obj interface{}) {
+	s, ok := obj.(pdfString)
+	if !ok || t.font == nil {
+		return
+	}
+
+	text, width, codes, spaces := t.font.decode(s)
+	for _, r := range text {
+		switch {
+		case r == '\n' || r == '\r':
+			t.newLine()
+		case r == ' ':
+			t.space()
+		case r > ' ' && r != 0xfffd:
+			t.buf.WriteRune(r)
+		}
+	}
+	t.advance(width*t.fontSize + float64(codes)*t.charSpace + float64(spaces)*t.wordSpace)
+}
+
+func (t *textWriter) newLine() {
+	if t.buf.Len() > 0 && !bytes.HasSuffix(t.buf.Bytes(), []byte("\n")) {
+		t.buf.WriteByte('\n')
+	}
+}
+
+func (t *textWriter) space() {
+	if t.buf.Len() > 0 && !bytes.HasSuffix(t.buf.Bytes(), []byte("\n")) && !bytes.HasSuffix(t.buf.Bytes(), []byte(" ")) {
+		t.buf.WriteByte(' ')
+	}
+}
+
+// pdfFont turns the strings shown with a font into text.
+type pdfFont struct {
+	// composite fonts have codes of several bytes, which mean nothing without a ToUnicode map
+	composite bool
+	toUnicode *cmap
+	// text of the one-byte codes of a simple font
+	encoding [256]string
+	// widths of the glyphs in thousandths of the font size
+	widths       map[int]float64
+	defaultWidth float64
+}
+
+// loadFont returns the font of the given name in the resources, nil if it is unknown.
+func (t *textWriter) loadFont(resources pdfDict, name pdfName) *pdfFont {
+	obj := t.doc.dict(resources["Font"])[name]
+	ref, isRef := obj.(pdfRef)
+	if font, ok := t.fonts[ref]; ok && isRef {
+		return font
+	}
+	dict := t.doc.dict(obj)
+	if dict == nil {
+		return nil
+	}
+
+	font := &pdfFont{
+		composite:    t.doc.name(dict["Subtype"]) == "Type0",
+		encoding:     winAnsiEncoding,
+		widths:       make(map[int]float64),
+		defaultWidth: 500,
+	}
+	t.loadWidths(font, dict)
+	if s := t.doc.stream(dict["ToUnicode"]); s != nil {
+		if data, err := t.doc.decode(s); err == nil {
+			font.toUnicode = parseCMap(data)
+		}
+	}
+	if encoding := t.doc.dict(dict["Encoding"]); encoding != nil {
+		code := 0
+		for _, item := range t.doc.array(encoding["Differences"]) {
+			switch item := t.doc.resolve(item).(type) {
+			case float64:
+				code = int(item)
+			case pdfName:
+				if code >= 0 && code < 256 {
+					if text, ok := glyphText(string(item)); ok {
+						font.encoding[code] = text
+					}
+				}
+				code++
+			}
+		}
+	}
+	if isRef {
+		t.fonts[ref] = font
+	}
+
+	return font
+}
+
+// loadWidths reads the widths of the glyphs of a simple font, or of the descendant of a composite one.
+// The widths of the standard fonts are not known: their glyphs are assumed to be half as wide as they are high.
+func (t *textWriter) loadWidths(font *pdfFont, dict pdfDict) {
+	if !font.composite {
+		first := int(t.doc.number(dict["FirstChar"]))
+		for i, w := range t.doc.array(dict["Widths"]) {
+			font.widths[first+i] = t.doc.number(w)
+		}
+		return
+	}
+
+	descendants := t.doc.array(dict["DescendantFonts"])
+	if len(descendants) == 0 {
+		return
+	}
+	cid := t.doc.dict(descendants[0])
+	font.defaultWidth = 1000
+	if dw, ok := t.doc.resolve(cid["DW"]).(float64); ok {
+		font.defaultWidth = dw
+	}
+	// Either c [w1 w2 ...] or cfirst clast w
+	w := t.doc.array(cid["W"])
+	for i := 0; i+1 < len(w); {
+		first := int(t.doc.number(w[i]))
+		if widths := t.doc.array(w[i+1]); widths != nil {
+			for j, width := range widths {
+				font.widths[first+j] = t.doc.number(width)
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		last := int(t.doc.number(w[i+1]))
+		for c := first; c <= last && c-first < maxCMapRange; c++ {
+			font.widths[c] = t.doc.number(w[i+2])
+		}
+		i += 3
+	}
+}
+
+// decode returns the text of the s string shown with the font, its width in thousandths
+// of the font size, its number of codes and how many of them are single-byte spaces.
+func (f *pdfFont) decode(s []byte) (string, float64, int, int) {
+	var (
+		text          strings.Builder
+		width         float64
+		codes, spaces int
+	)
+	for len(s) > 0 {
+		n := 1
+		if f.toUnicode != nil {
+			n = f.toUnicode.codeLength(s, f.composite)
+		} else if f.composite {
+			n = 2
+		}
+		if n > len(s) {
+			n = len(s)
+		}
+
+		code := 0
+		for _, b := range s[:n] {
+			code = code<<8 | int(b)
+		}
+		s = s[n:]
+
+		codes++
+		if n == 1 && code == ' ' {
+			spaces++
+		}
+		if w, ok := f.widths[code]; ok {
+			width += w / 1000
+		} else {
+			width += f.defaultWidth / 1000
+		}
+
+		if f.toUnicode != nil {
+			if u, ok := f.toUnicode.chars[code]; ok {
+				text.WriteString(u)
+				continue
+			}
+		}
+		if !f.composite && code < 256 {
+			text.WriteString(f.encoding[code])
+		}
+	}
+
+	return text.String(), width, codes, spaces
+}
+
+// cmap is a ToUnicode map, giving the text of the codes of a font.
+type cmap struct {
+	// ranges of codes, telling the length of the codes
+	ranges []codeRange
+	chars  map[int]string
+}
+
+type codeRange struct {
+	lo, hi []byte
+}
+
+// Number of codes of a bfrange above which it is ignored
+const maxCMapRange = 1 << 16
+
+// parseCMap reads the codespace ranges, bfchar and bfrange sections of a ToUnicode map.
+func parseCMap(data []byte) *cmap {
+	c := &cmap{chars: make(map[int]string)}
+	l := &lexer{data: data}
+	var operands []interface{}
+	for {
+		obj, err := l.object()
+		if err != nil {
+			return c
+		}
+		op, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				lo, _ := operands[i].(pdfString)
+				hi, _ := operands[i+1].(pdfString)
+				if len(lo) > 0 && len(lo) == len(hi) {
+					c.ranges = append(c.ranges, codeRange{lo, hi})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, _ := operands[i].(pdfString)
+				dst, _ := operands[i+1].(pdfString)
+				if len(src) > 0 && len(src) <= 4 {
+					c.chars[codeOf(src)] = utf16Text(dst, 0)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, _ := operands[i].(pdfString)
+				hi, _ := operands[i+1].(pdfString)
+				if len(lo) == 0 || len(lo) > 4 || len(lo) != len(hi) {
+					continue
+				}
+				first, last := codeOf(lo), codeOf(hi)
+				if last < first || last-first >= maxCMapRange {
+					continue
+				}
+				for code := first; code <= last; code++ {
+					switch dst := operands[i+2].(type) {
+					case pdfString:
+						c.chars[code] = utf16Text(dst, code-first)
+					case pdfArray:
+						if code-first >= len(dst) {
+							break
+						}
+						if s, ok := dst[code-first].(pdfString); ok {
+							c.chars[code] = utf16Text(s, 0)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// codeLength returns the length of the code starting s, as given by the codespace ranges.
+func (c *cmap) codeLength(s []byte, composite bool) int {
+	for _, r := range c.ranges {
+		if len(r.lo) > len(s) {
+			continue
+		}
+		in := true
+		for i := range r.lo {
+			if s[i] < r.lo[i] || s[i] > r.hi[i] {
+				in = false
+				break
+			}
+		}
+		if in {
+			return len(r.lo)
+		}
+	}
+	if composite {
+		return 2
+	}
+
+	return 1
+}
+
+func codeOf(s []byte) (code int) {
+	for _, b := range s {
+		code = code<<8 | int(b)
+	}
+
+	return
+}
+
+// utf16Text decodes UTF-16BE text, its last unit being incremented by inc.
+func utf16Text(s []byte, inc int) string {
+	units := make([]uint16, len(s)/2)
+	for i := range units {
+		units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+	}
+	if len(units) > 0 {
+		units[len(units)-1] += uint16(inc)
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// Text of the codes of the simple fonts without ToUnicode map: Latin-1,
+// with the punctuation of Windows in the codes left to control characters
+var winAnsiEncoding = func() (encoding [256]string) {
+	for code := ' '; code < 256; code++ {
+		encoding[code] = string(code)
+	}
+	encoding[127] = ""
+	for code, r := range map[int]rune{
+		0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡',
+		0x88: 'ˆ', 0x89: '‰', 0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ', 0x8e: 'Ž', 0x91: '‘',
+		0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜',
+		0x99: '™', 0x9a: 'š', 0x9b: '›', 0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+	} {
+		encoding[code] = string(r)
+	}
+	for code := 0x80; code < 0xa0; code++ {
+		if encoding[code] == string(rune(code)) {
+			encoding[code] = ""
+		}
+	}
+
+	return
+}()
+
+// Text of the glyph names of the Differences arrays that are not the character itself
+var glyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#", "dollar": "$",
+	"percent": "%", "ampersand": "&", "quotesingle": "'", "quoteright": "’", "quoteleft": "‘",
+	"parenleft": "(", "parenright": ")", "asterisk": "*", "plus": "+", "comma": ",",
+	"hyphen": "-", "minus": "−", "period": ".", "slash": "/", "colon": ":", "semicolon": ";",
+	"less": "<", "equal": "=", "greater": ">", "question": "?", "at": "@",
+	"bracketleft": "[", "backslash": "\\", "bracketright": "]", "asciicircum": "^",
+	"underscore": "_", "grave": "`", "braceleft": "{", "bar": "|", "braceright": "}",
+	"asciitilde": "~", "quotedblleft": "“", "quotedblright": "”", "quotesinglbase": "‚",
+	"quotedblbase": "„", "guillemotleft": "«", "guillemotright": "»", "bullet": "•",
+	"endash": "–", "emdash": "—", "ellipsis": "…", "dagger": "†", "daggerdbl": "‡",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl", "degree": "°",
+	"copyright": "©", "registered": "®", "trademark": "™", "section": "§", "paragraph": "¶",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4", "five": "5", "six": "6",
+	"seven": "7", "eight": "8", "nine": "9", "Euro": "€", "sterling": "£", "yen": "¥",
+	"agrave": "à", "acircumflex": "â", "ccedilla": "ç", "eacute": "é", "egrave": "è",
+	"ecircumflex": "ê", "edieresis": "ë", "icircumflex": "î", "idieresis": "ï",
+	"ocircumflex": "ô", "ugrave": "ù", "ucircumflex": "û", "udieresis": "ü",
+	"adieresis": "ä", "odieresis": "ö", "germandbls": "ß", "oe": "œ", "ae": "æ",
+	"Agrave": "À", "Ccedilla": "Ç", "Eacute": "É", "Egrave": "È", "Ecircumflex": "Ê",
+}
+
+// glyphText returns the text of a glyph name: a known name, a single character
+// or a uniXXXX name. Other names are left to the base encoding.
+func glyphText(name string) (string, bool) {
+	if text, ok := glyphNames[name]; ok {
+		return text, true
+	}
+	if len([]rune(name)) == 1 {
+		return name, true
+	}
+	if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if code, err := strconv.ParseUint(name[3:], 16, 16); err == nil {
+			return string(rune(code)), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,24 @@
+//go:build go1.18
+// +build go1.18
+
+package extractor
+
+import (
+	"testing"
+
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// FuzzGoExtract feeds the pure-Go extractor with broken documents, which must never crash it.
+func FuzzGoExtract(f *testing.F) {
+	f.Add(testPage("BT /F1 12 Tf 72 720 Td (Hello) Tj 0 -14 Td [(Second) -250 (line)] TJ ET"))
+	f.Add(testPdf(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		testStream("/Type /ObjStm /N 2 /First 10", "3 0 4 40 << /Type /Pages /Kids [4 0 R] >> << /Type /Page >>", true),
+	))
+	f.Add([]byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj trailer << /Root 1 0 R >>"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		extractBytes(data, &messaging.ExtractionOptions{})
+	})
+}
@@ -0,0 +1,143 @@
+package extractor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// testPdf returns a pdf made of the objects, numbered from 1, the first one being the catalog.
+func testPdf(objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	return b.Bytes()
+}
+
+// testStream returns a stream object of the data, deflated if asked.
+func testStream(dict string, data string, deflate bool) string {
+	if deflate {
+		var b bytes.Buffer
+		z := zlib.NewWriter(&b)
+		z.Write([]byte(data))
+		z.Close()
+		data = b.String()
+		dict += " /Filter /FlateDecode"
+	}
+
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// testPage returns a pdf of one page showing the content with Helvetica.
+func testPage(content string) []byte {
+	return testPdf(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		testStream("", content, true),
+	)
+}
+
+func extractBytes(data []byte, opts *messaging.ExtractionOptions) (string, error) {
+	var out bytes.Buffer
+	err := extractText(context.Background(), data, &out, opts)
+
+	return out.String(), err
+}
+
+func TestGoExtractText(t *testing.T) {
+	data := testPage("BT /F1 12 Tf 72 720 Td (Hello) Tj ( world) Tj 0 -14 Td [(Second) -250 (line)] TJ ET")
+
+	text, err := extractBytes(data, &messaging.ExtractionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Hello world\nSecond line\n\f" {
+		t.Errorf("text is %q", text)
+	}
+}
+
+func TestGoExtractMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		kind messaging.ErrorKind
+	}{
+		{"empty", nil, messaging.ErrorKind_ErrorInvalidPdf},
+		{"not a pdf", []byte("hello"), messaging.ErrorKind_ErrorInvalidPdf},
+		{"no catalog", testPdf("<< /Type /Pages /Kids [] >>"), messaging.ErrorKind_ErrorInvalidPdf},
+		{"truncated", testPage("BT /F1 12 Tf (Hello) Tj ET")[:120], messaging.ErrorKind_ErrorInvalidPdf},
+		{"deep nesting", testPdf("<< /Type /Catalog /Pages " + strings.Repeat("[", 1<<20) + " >>"), messaging.ErrorKind_ErrorInvalidPdf},
+		{"deep dictionaries", testPdf("<< /Type /Catalog /Pages " + strings.Repeat("<< /A ", 1<<18) + " >>"), messaging.ErrorKind_ErrorInvalidPdf},
+		{"huge length", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] >>",
+			"<< /Type /Page /Contents 4 0 R >>",
+			"<< /Length 1e300 >>\nstream\nBT (x) Tj ET\nendstream",
+		), 0},
+		{"negative length", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] >>",
+			"<< /Type /Page /Contents 4 0 R >>",
+			"<< /Length -5 >>\nstream\nBT (x) Tj ET\nendstream",
+		), 0},
+		{"length loop", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] >>",
+			"<< /Type /Page /Contents 4 0 R >>",
+			"<< /Length 5 0 R >>\nstream\nBT (x) Tj ET\nendstream",
+			"<< /Length 4 0 R >>\nstream\nx\nendstream",
+		), 0},
+		{"negative object stream offset", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			testStream("/Type /ObjStm /N 1 /First 8", "3 -1000000 << /Type /Pages /Kids [] >>", true),
+		), 0},
+		{"huge object stream offset", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			testStream("/Type /ObjStm /N 1 /First 8", "3 1e300 << >>", true),
+		), 0},
+		{"huge object stream count", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			testStream("/Type /ObjStm /N 1e300 /First 8", "3 0 << >>", true),
+		), 0},
+		{"page tree loop", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [2 0 R 3 0 R] >>",
+			"<< /Type /Pages /Kids [2 0 R] >>",
+		), 0},
+		{"deep page tree", testPdf(
+			"<< /Type /Catalog /Pages " + strings.Repeat("<< /Type /Pages /Kids [", 10000) + strings.Repeat("] >>", 10000) + " >>",
+		), messaging.ErrorKind_ErrorInvalidPdf},
+		{"bad font maps", testPdf(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] >>",
+			"<< /Type /Page /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+			"<< /Type /Font /Subtype /Type0 /ToUnicode 6 0 R >>",
+			testStream("", "BT /F1 12 Tf <0001ffff> Tj ET", false),
+			testStream("", "1 begincodespacerange <00> endcodespacerange 2 beginbfrange <00> <ff> [] <01> <00> <> endbfrange", false),
+		), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := extractBytes(test.data, &messaging.ExtractionOptions{})
+			if test.kind == 0 {
+				// Anything but a crash will do
+				return
+			}
+			if detail := messaging.ErrorDetailOf(err); detail == nil || detail.Kind != test.kind {
+				t.Errorf("error is %v, expected a %s", err, test.kind)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// mutool runs mutool of MuPDF, which writes UTF-8 text in reading order.
+type mutool struct{}
+
+func (mutool) Name() string {
+	return Mutool
+}
+
+func (mutool) Available() error {
+	_, err := exec.LookPath("mutool")
+	if err != nil {
+		return errors.Wrapf(err,
+			"mutool is not found")
+	}
+
+	return nil
+}
+
+func (mutool) Check(opts *messaging.ExtractionOptions) error {
+	if opts.Raw {
+		return unsupported(Mutool, "raw mode")
+	}
+
+	return checkPlain(Mutool, opts)
+}
+
+// Extract has mutool write the text of each page into its own file next to fn,
+// then writes the pages in order with the page breaks and end of lines of the options.
+func (mutool) Extract(ctx context.Context, run Runner, fn string, w io.Writer, opts *messaging.ExtractionOptions) (err error) {
+	if err = (mutool{}).Check(opts); err != nil {
+		return
+	}
+
+	first, last := int(opts.FirstPage), "N"
+	if first == 0 {
+		first = 1
+	}
+	if opts.LastPage != 0 {
+		last = fmt.Sprint(opts.LastPage)
+	}
+	pattern := strings.TrimSuffix(fn, filepath.Ext(fn)) + ".page%d.txt"
+
+	var stderr bytes.Buffer
+	cmd := run.Command(ctx, "mutool", "draw", "-q", "-F", "txt", "-o", pattern, fn, fmt.Sprintf("%d-%s", first, last))
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	// The pages written before a failure are removed as well
+	defer func() {
+		for i := first; ; i++ {
+			if os.Remove(fmt.Sprintf(pattern, i)) != nil {
+				break
+			}
+		}
+	}()
+	if err != nil {
+		return run.Error(ctx, "mutool", err, stderr.Bytes())
+	}
+
+	for i := first; ; i++ {
+		text, err := ioutil.ReadFile(fmt.Sprintf(pattern, i))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err,
+				"can't read the text of page %d",
+				i)
+		}
+		// Page breaks are the ones of the options
+		err = writePage(w, bytes.TrimRight(text, "\f\n"), opts)
+		if err != nil {
+			return err
+		}
+	}
+}
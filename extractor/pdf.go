@@ -0,0 +1,682 @@
+package extractor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Size of a decoded stream above which the document is refused, as a guard against
+// compression bombs: the pure-Go extractor runs inside the worker, out of the sandbox.
+const maxStreamSize = 64 << 20
+
+// Size of the streams decoded for a document beyond which the document is refused.
+// They are all kept, as the pages may share them.
+const maxDecodedSize = 256 << 20
+
+// Depth of the arrays and dictionaries nested in each other, and of the objects
+// needing each other to be read, beyond which a document is refused
+const maxNesting = 100
+
+// Objects of a pdf file
+type (
+	pdfName   string
+	pdfString []byte
+	pdfArray  []interface{}
+	pdfDict   map[pdfName]interface{}
+	// keyword is a bare word: an operator of a content stream, obj, R, stream...
+	// or a delimiter ending an array or a dictionary
+	keyword string
+	pdfRef  struct {
+		num, gen int
+	}
+	pdfStream struct {
+		dict pdfDict
+		// still encoded
+		data []byte
+	}
+)
+
+// lexer reads the objects of pdf data from pos.
+type lexer struct {
+	data []byte
+	pos  int
+	// arrays and dictionaries being read
+	depth int
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+
+	return false
+}
+
+// skipSpace moves after the white space and the comments.
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\r' && l.data[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// token returns the next number, name, string or keyword, or io.EOF at the end of the data.
+// Unexpected delimiters are returned as keywords, so broken data can be skipped.
+func (l *lexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+
+	switch c := l.data[l.pos]; c {
+	case '(':
+		return l.literalString()
+	case '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return keyword("<<"), nil
+		}
+		return l.hexString()
+	case '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return keyword(">>"), nil
+		}
+		l.pos++
+		return keyword(">"), nil
+	case '/':
+		return l.name(), nil
+	case '[', ']', '{', '}', ')':
+		l.pos++
+		return keyword(c), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	if isNumber(word) {
+		if n, err := strconv.ParseFloat(word, 64); err == nil {
+			return n, nil
+		}
+	}
+
+	return keyword(word), nil
+}
+
+// isNumber tells whether the word is written like a pdf number, which ParseFloat is more lenient about.
+func isNumber(word string) bool {
+	digits := false
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '.', (c == '+' || c == '-') && i == 0:
+		default:
+			return false
+		}
+	}
+
+	return digits
+}
+
+func (l *lexer) literalString() (pdfString, error) {
+	l.pos++
+	var s pdfString
+	for depth := 1; l.pos < len(l.data); {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				continue
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// Line continuation
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				n := int(c - '0')
+				for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+					n = n*8 + int(l.data[l.pos]-'0')
+					l.pos++
+				}
+				c = byte(n)
+			}
+		}
+		s = append(s, c)
+	}
+
+	return nil, errors.Errorf("string is not terminated")
+}
+
+func (l *lexer) hexString() (pdfString, error) {
+	l.pos++
+	end := bytes.IndexByte(l.data[l.pos:], '>')
+	if end < 0 {
+		return nil, errors.Errorf("hexadecimal string is not terminated")
+	}
+	s := decodeHex(l.data[l.pos : l.pos+end])
+	l.pos += end + 1
+
+	return s, nil
+}
+
+// decodeHex decodes hexadecimal digits separated by white space. A missing last digit is 0.
+func decodeHex(data []byte) []byte {
+	digits := make([]byte, 0, len(data)+1)
+	for _, c := range data {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	hex.Decode(s, digits)
+
+	return s
+}
+
+func (l *lexer) name() pdfName {
+	l.pos++
+	var name []byte
+	for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '#' && l.pos+2 <= len(l.data) {
+			if b, err := hex.DecodeString(string(l.data[l.pos : l.pos+2])); err == nil {
+				c = b[0]
+				l.pos += 2
+			}
+		}
+		name = append(name, c)
+	}
+
+	return pdfName(name)
+}
+
+// object returns the next object, arrays and dictionaries included.
+// Operators and delimiters are returned as keywords.
+func (l *lexer) object() (interface{}, error) {
+	tok, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case keyword:
+		if t == "[" || t == "<<" {
+			if l.depth >= maxNesting {
+				return nil, errors.Errorf("objects are nested too deeply")
+			}
+			l.depth++
+			defer func() { l.depth-- }()
+		}
+		switch t {
+		case "[":
+			arr := pdfArray{}
+			for {
+				obj, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if obj == keyword("]") {
+					return arr, nil
+				}
+				arr = append(arr, obj)
+			}
+		case "<<":
+			dict := pdfDict{}
+			for {
+				key, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if key == keyword(">>") {
+					return dict, nil
+				}
+				name, ok := key.(pdfName)
+				if !ok {
+					return nil, errors.Errorf("key of a dictionary is not a name")
+				}
+				val, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				if val == keyword(">>") {
+					return dict, nil
+				}
+				dict[name] = val
+			}
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	case float64:
+		// An integer may start an indirect reference
+		if t >= 0 && t == math.Trunc(t) {
+			pos := l.pos
+			if gen, err := l.token(); err == nil {
+				if g, ok := gen.(float64); ok && g >= 0 && g == math.Trunc(g) {
+					if r, err := l.token(); err == nil && r == keyword("R") {
+						return pdfRef{int(t), int(g)}, nil
+					}
+				}
+			}
+			l.pos = pos
+		}
+	}
+
+	return tok, nil
+}
+
+// Header of an object: number, generation and obj
+var objHeader = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+
+// Start of the trailer of a cross-reference table
+var trailerHeader = regexp.MustCompile(`trailer\s*<<`)
+
+// pdfDoc gives the objects of a pdf file. The objects are found by scanning the file
+// rather than by reading its cross-reference tables, which are often broken.
+type pdfDoc struct {
+	data []byte
+	// position of each object written as is in the file, after its header
+	offsets map[int]int
+	objects map[int]interface{}
+	// objects stored in object streams
+	compressed map[int]interface{}
+	root       interface{}
+	// objects being read, each one needing the next one
+	depth int
+	// streams already decoded, and their total size
+	decoded     map[*pdfStream]decodedStream
+	decodedSize int
+}
+
+// decodedStream is the result of decode for a stream.
+type decodedStream struct {
+	data []byte
+	err  error
+}
+
+// openPdf finds the objects of the pdf data and its catalog.
+func openPdf(data []byte) (d *pdfDoc, err error) {
+	if !bytes.Contains(data[:minInt(len(data), 1024)], []byte("%PDF-")) {
+		return nil, errors.Errorf("file is not a pdf")
+	}
+
+	d = &pdfDoc{
+		data:       data,
+		offsets:    make(map[int]int),
+		objects:    make(map[int]interface{}),
+		compressed: make(map[int]interface{}),
+		decoded:    make(map[*pdfStream]decodedStream),
+	}
+	for _, m := range objHeader.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err == nil {
+			// The last definition is the one of the latest update
+			d.offsets[num] = m[1]
+		}
+	}
+
+	// The trailer of a file is a dictionary after its cross-reference
+	// table, or the dictionary of its cross-reference stream
+	type trailer struct {
+		pos  int
+		dict pdfDict
+	}
+	var trailers []trailer
+	for num, pos := range d.offsets {
+		switch obj := d.object(num).(type) {
+		case *pdfStream:
+			switch d.name(obj.dict["Type"]) {
+			case "ObjStm":
+				d.loadObjectStream(obj)
+			case "XRef":
+				trailers = append(trailers, trailer{pos, obj.dict})
+			}
+		case pdfDict:
+			if d.name(obj["Type"]) == "Catalog" && d.root == nil {
+				d.root = obj
+			}
+		}
+	}
+	for _, m := range trailerHeader.FindAllIndex(data, -1) {
+		l := &lexer{data: data, pos: m[1] - 2}
+		if dict, ok := l.mustObject().(pdfDict); ok {
+			trailers = append(trailers, trailer{m[0], dict})
+		}
+	}
+	sort.Slice(trailers, func(i, j int) bool { return trailers[i].pos > trailers[j].pos })
+
+	for _, t := range trailers {
+		if t.dict["Root"] == nil {
+			continue
+		}
+		if t.dict["Encrypt"] != nil {
+			return nil, errEncrypted
+		}
+		d.root = d.dict(t.dict["Root"])
+		break
+	}
+	if d.root == nil {
+		return nil, errors.Errorf("catalog of the document is not found")
+	}
+
+	return d, nil
+}
+
+// Failure of openPdf for the documents it can't read without a password
+var errEncrypted = errors.New("document is encrypted")
+
+// mustObject is object, nil on failure.
+func (l *lexer) mustObject() interface{} {
+	obj, err := l.object()
+	if err != nil {
+		return nil
+	}
+
+	return obj
+}
+
+// object returns the num object, or nil if it is unknown.
+func (d *pdfDoc) object(num int) interface{} {
+	if obj, ok := d.objects[num]; ok {
+		return obj
+	}
+	pos, ok := d.offsets[num]
+	if !ok {
+		return d.compressed[num]
+	}
+	if d.depth >= maxNesting {
+		return nil
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+
+	// Guards against objects depending on themselves through the length of their stream
+	d.objects[num] = nil
+	l := &lexer{data: d.data, pos: pos}
+	obj := l.mustObject()
+	if dict, ok := obj.(pdfDict); ok {
+		if tok, err := l.token(); err == nil && tok == keyword("stream") {
+			obj = &pdfStream{dict: dict, data: d.streamData(dict, l.pos)}
+		}
+	}
+	d.objects[num] = obj
+
+	return obj
+}
+
+// streamData returns the data of the stream starting after the stream keyword at pos.
+// A wrong length is replaced by the distance to the endstream keyword.
+func (d *pdfDoc) streamData(dict pdfDict, pos int) []byte {
+	if pos < len(d.data) && d.data[pos] == '\r' {
+		pos++
+	}
+	if pos < len(d.data) && d.data[pos] == '\n' {
+		pos++
+	}
+
+	// The length is checked before being converted, a huge one would overflow
+	if n, ok := d.resolve(dict["Length"]).(float64); ok && n >= 0 && n <= float64(len(d.data)-pos) {
+		end := pos + int(n)
+		after := bytes.TrimLeft(d.data[end:minInt(len(d.data), end+32)], " \t\r\n")
+		if bytes.HasPrefix(after, []byte("endstream")) {
+			return d.data[pos:end]
+		}
+	}
+
+	end := bytes.Index(d.data[pos:], []byte("endstream"))
+	if end < 0 {
+		return d.data[pos:]
+	}
+
+	return bytes.TrimRight(d.data[pos:pos+end], "\r\n")
+}
+
+// loadObjectStream adds the objects of the object stream. The objects written as is
+// in the file are the ones of the latest update, if any.
+func (d *pdfDoc) loadObjectStream(s *pdfStream) {
+	data, err := d.decode(s)
+	if err != nil {
+		return
+	}
+	n, _ := d.resolve(s.dict["N"]).(float64)
+	first, _ := d.resolve(s.dict["First"]).(float64)
+
+	header := &lexer{data: data}
+	for i := 0; float64(i) < n; i++ {
+		num, ok := header.mustObject().(float64)
+		if !ok {
+			return
+		}
+		pos, ok := header.mustObject().(float64)
+		if !ok {
+			return
+		}
+		// Offsets are checked before being converted, a huge one would overflow
+		if _, direct := d.offsets[int(num)]; direct || first+pos < 0 || first+pos >= float64(len(data)) {
+			continue
+		}
+		l := &lexer{data: data, pos: int(first + pos)}
+		d.compressed[int(num)] = l.mustObject()
+	}
+}
+
+// resolve returns the object referenced by obj, or obj itself if it is not a reference.
+func (d *pdfDoc) resolve(obj interface{}) interface{} {
+	// References to references are allowed, but not endlessly
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = d.object(ref.num)
+	}
+
+	return nil
+}
+
+// dict returns the dictionary given by obj, or the one of a stream, nil if it is something else.
+func (d *pdfDoc) dict(obj interface{}) pdfDict {
+	switch obj := d.resolve(obj).(type) {
+	case pdfDict:
+		return obj
+	case *pdfStream:
+		return obj.dict
+	}
+
+	return nil
+}
+
+func (d *pdfDoc) array(obj interface{}) pdfArray {
+	arr, _ := d.resolve(obj).(pdfArray)
+	return arr
+}
+
+func (d *pdfDoc) name(obj interface{}) pdfName {
+	name, _ := d.resolve(obj).(pdfName)
+	return name
+}
+
+func (d *pdfDoc) number(obj interface{}) float64 {
+	n, _ := d.resolve(obj).(float64)
+	return n
+}
+
+func (d *pdfDoc) stream(obj interface{}) *pdfStream {
+	s, _ := d.resolve(obj).(*pdfStream)
+	return s
+}
+
+// decode returns the data of the stream once its filters are applied. Each stream is decoded
+// once, the streams of the document being refused once they exceed maxDecodedSize in total.
+func (d *pdfDoc) decode(s *pdfStream) ([]byte, error) {
+	if res, ok := d.decoded[s]; ok {
+		return res.data, res.err
+	}
+	if d.decodedSize > maxDecodedSize {
+		return nil, errTooLarge
+	}
+
+	data, err := d.applyFilters(s)
+	d.decoded[s] = decodedStream{data, err}
+	d.decodedSize += len(data)
+
+	return data, err
+}
+
+// Failure of decode once the streams of the document are too large
+var errTooLarge = errors.Errorf("streams are larger than %d bytes once decoded", maxDecodedSize)
+
+// applyFilters decodes the data of the stream.
+func (d *pdfDoc) applyFilters(s *pdfStream) (data []byte, err error) {
+	var filters []pdfName
+	switch f := d.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = append(filters, f)
+	case pdfArray:
+		for _, name := range f {
+			filters = append(filters, d.name(name))
+		}
+	}
+	var params []pdfDict
+	switch p := d.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = append(params, p)
+	case pdfArray:
+		for _, param := range p {
+			params = append(params, d.dict(param))
+		}
+	}
+
+	data = s.data
+	for i, filter := range filters {
+		if i < len(params) && d.number(params[i]["Predictor"]) > 1 {
+			return nil, errors.Errorf("predictors are not supported")
+		}
+		switch filter {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+		case "ASCIIHexDecode", "AHx":
+			if end := bytes.IndexByte(data, '>'); end >= 0 {
+				data = data[:end]
+			}
+			data = decodeHex(data)
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		default:
+			err = errors.Errorf("filter %s is not supported", filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// inflate decompresses zlib data. What can be read from truncated data is kept.
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"failed to decompress stream")
+	}
+	defer r.Close()
+
+	out, err := ioutil.ReadAll(io.LimitReader(r, maxStreamSize+1))
+	if len(out) > maxStreamSize {
+		return nil, errors.Errorf("stream is larger than %d bytes once decompressed", maxStreamSize)
+	}
+	if err != nil && len(out) == 0 {
+		return nil, errors.Wrapf(err,
+			"failed to decompress stream")
+	}
+
+	return out, nil
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if end := bytes.Index(data, []byte("~>")); end >= 0 {
+		data = data[:end]
+	}
+
+	out, err := ioutil.ReadAll(ascii85.NewDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return nil, errors.Wrapf(err,
+			"failed to decode ASCII85 stream")
+	}
+
+	return out, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
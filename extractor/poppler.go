@@ -0,0 +1,47 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// poppler runs pdftotext of poppler-utils, which supports all the extraction options.
+type poppler struct{}
+
+func (poppler) Name() string {
+	return Poppler
+}
+
+func (poppler) Available() error {
+	_, err := exec.LookPath("pdftotext")
+	if err != nil {
+		return errors.Wrapf(err,
+			"pdftotext is not found")
+	}
+
+	return nil
+}
+
+func (poppler) Check(opts *messaging.ExtractionOptions) error {
+	return nil
+}
+
+// Extract has pdftotext write the text to its standard output.
+func (poppler) Extract(ctx context.Context, run Runner, fn string, w io.Writer, opts *messaging.ExtractionOptions) (err error) {
+	var stderr bytes.Buffer
+	cmd := run.Command(ctx, "pdftotext", messaging.PdftotextArgs(opts, fn, "-")...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return run.Error(ctx, "pdftotext", err, stderr.Bytes())
+	}
+
+	return
+}
@@ -10,6 +10,7 @@ require (
 	github.com/urfave/cli/v2 v2.1.1
 	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55
 	google.golang.org/grpc v1.27.0
 	google.golang.org/protobuf v1.23.0
 )
@@ -15,6 +15,14 @@ func main() {
 			&cmd.WorkerServe,
 			&cmd.Serve,
 			&cmd.PdfToText,
+			&cmd.Status,
+			&cmd.Fetch,
+			&cmd.Cancel,
+			&cmd.Delete,
+			&cmd.Metadata,
+			&cmd.HealthCheck,
+			&cmd.Workers,
+			&cmd.Jobs,
 		},
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
@@ -1,6 +1,7 @@
 package messaging
 
 import (
+	"crypto/sha256"
 	"io"
 	"os"
 
@@ -15,6 +16,74 @@ type ChunkSender interface {
 	Send(*Chunk) error
 }
 
+type TextChunkReceiver interface {
+	Recv() (*TextChunk, error)
+}
+
+type TextChunkSender interface {
+	Send(*TextChunk) error
+}
+
+type limitedReceiver struct {
+	stream   ChunkReceiver
+	maxSize  int64
+	received int64
+}
+
+//LimitChunks function returns a receiver that fails with a ErrorTooLarge error
+//once more than maxSize bytes of content are received. A zero maxSize means no limit.
+func LimitChunks(stream ChunkReceiver, maxSize int64) ChunkReceiver {
+	if maxSize == 0 {
+		return stream
+	}
+
+	return &limitedReceiver{
+		stream:  stream,
+		maxSize: maxSize,
+	}
+}
+
+func (r *limitedReceiver) Recv() (chunk *Chunk, err error) {
+	chunk, err = r.stream.Recv()
+	if err != nil {
+		return
+	}
+
+	r.received += int64(len(chunk.Content))
+	if r.received > r.maxSize {
+		return nil, NewError(ErrorKind_ErrorTooLarge,
+			"file is larger than %d bytes", r.maxSize)
+	}
+
+	return
+}
+
+//HashFile function returns the SHA-256 digest and the size of the file.
+func HashFile(filename string) (hash *FileHash, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to open file %s",
+			filename)
+		return
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to read file %s",
+			filename)
+		return
+	}
+
+	return &FileHash{
+		Sha256: h.Sum(nil),
+		Size:   size,
+	}, nil
+}
+
 //ReceiveFile function receives a file by reading the stream.
 //As a pointer to the file is returned, it's up to the caller to remove/close this file.
 func ReceiveFile(stream ChunkReceiver, filename string) (file *os.File, err error) {
@@ -26,25 +95,81 @@ func ReceiveFile(stream ChunkReceiver, filename string) (file *os.File, err erro
 		return nil, err
 	}
 
+	err = ReceiveContent(stream, file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err,
+			"failed to receive file %s",
+			filename)
+	}
+
+	return file, nil
+}
+
+//ReceiveContent function writes the content received by stream into w until the end of the stream.
+//Each chunk is checked against its CRC32C, and the whole content against the SHA-256 digest
+//of the trailer, if the sender has set them. A sender setting the CRC32C must send the trailer.
+func ReceiveContent(stream ChunkReceiver, w io.Writer) (err error) {
+	var (
+		h       = sha256.New()
+		trailer bool
+		checked bool
+	)
+
 	for {
 		chunk, err := stream.Recv()
 		if err != nil {
 			if err == io.EOF {
-				return file, nil
+				if checked && !trailer {
+					return NewError(ErrorKind_ErrorCorrupted,
+						"content ends without its trailer")
+				}
+				return nil
 			}
 
-			return nil, errors.Wrapf(err,
+			return errors.Wrapf(err,
 				"failed unexpectadely while reading chunks from stream")
 		}
-		_, err = file.Write(chunk.Content)
+
+		switch {
+		case trailer:
+			return NewError(ErrorKind_ErrorCorrupted,
+				"content is received after the trailer")
+		case IsTrailer(chunk):
+			trailer = true
+			err = VerifyTrailer(chunk, h.Sum(nil))
+		default:
+			checked = checked || chunk.Crc32C != 0
+			err = VerifyChunk(chunk)
+		}
 		if err != nil {
-			return nil, errors.Wrapf(err,
-				"failed to write into file %s",
-				filename)
+			return err
+		}
+
+		h.Write(chunk.Content)
+		_, err = w.Write(chunk.Content)
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed to write received content")
 		}
 	}
 }
 
+//SendFileAt function sends a file by stream from the offset, each chunk
+//carrying its position in the file.
+func SendFileAt(stream ChunkSender, chunkSize int, filename string, offset int64) (err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to open file %s",
+			filename)
+		return
+	}
+	defer file.Close()
+
+	return sendContent(stream, chunkSize, file, offset)
+}
+
 //SendFile function sends a file by stream. If file needs to be removed,
 //the toremove parameter should be set to true
 func SendFile(
@@ -53,10 +178,7 @@ func SendFile(
 	filename string,
 	toremove bool) (err error) {
 	var (
-		writing = true
-		buf     []byte
-		n       int
-		file    *os.File
+		file *os.File
 	)
 	// Get a file handle for the file we want to process
 	file, err = os.Open(filename)
@@ -67,13 +189,145 @@ func SendFile(
 		return
 	}
 
+	err = sendContent(stream, chunkSize, file, 0)
+	file.Close()
+	if err != nil {
+		return
+	}
+
+	if toremove {
+		if os.Remove(filename) != nil {
+			err = errors.Wrapf(err,
+				"failed to remove tmp file")
+			return
+		}
+	}
+
+	return
+}
+
+// sendContent sends the content of the file from the offset, each chunk carrying its
+// position and its CRC32C, then the trailer with the SHA-256 digest of the whole file.
+func sendContent(stream ChunkSender, chunkSize int, file *os.File, offset int64) (err error) {
+	h := sha256.New()
+	// The digest covers the content sent before the offset as well
+	_, err = io.CopyN(h, file, offset)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to read offset %d of file %s",
+			offset, file.Name())
+		return
+	}
+
+	err = sendChunks(io.TeeReader(file, h), chunkSize, func(content []byte) (err error) {
+		err = stream.Send(&Chunk{
+			Content: content,
+			Offset:  offset,
+			Crc32C:  ContentChecksum(content),
+		})
+		offset += int64(len(content))
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	err = stream.Send(&Chunk{
+		Sha256: h.Sum(nil),
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to send trailer via stream")
+		return
+	}
+
+	return
+}
+
+//SendText function sends the text read from r by stream, as soon as it is read,
+//then its SHA-256 digest in a last chunk.
+func SendText(stream TextChunkSender, chunkSize int, r io.Reader) (err error) {
+	h := sha256.New()
+	err = sendChunks(io.TeeReader(r, h), chunkSize, func(content []byte) error {
+		return stream.Send(&TextChunk{
+			Content: content,
+		})
+	})
+	if err != nil {
+		return
+	}
+
+	err = stream.Send(&TextChunk{
+		Sha256: h.Sum(nil),
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to send text digest via stream")
+		return
+	}
+
+	return
+}
+
+//ReceiveText function writes the text received by stream into w until the end of the stream,
+//which must come after the chunk ending the text with its SHA-256 digest.
+func ReceiveText(stream TextChunkReceiver, w io.Writer) (err error) {
+	var (
+		h      = sha256.New()
+		digest bool
+	)
+
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				if !digest {
+					return NewError(ErrorKind_ErrorCorrupted,
+						"text ends without its digest")
+				}
+				return nil
+			}
+
+			return errors.Wrapf(err,
+				"failed unexpectadely while reading text chunks from stream")
+		}
+
+		if digest {
+			return NewError(ErrorKind_ErrorCorrupted,
+				"text is received after its digest")
+		}
+		if len(chunk.Sha256) != 0 {
+			digest = true
+			err = VerifyTextDigest(chunk, h.Sum(nil))
+			if err != nil {
+				return err
+			}
+		}
+
+		h.Write(chunk.Content)
+		_, err = w.Write(chunk.Content)
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed to write received text")
+		}
+	}
+}
+
+//sendChunks reads r by pieces of chunkSize bytes and gives each of them to send.
+func sendChunks(r io.Reader, chunkSize int, send func([]byte) error) (err error) {
+	var (
+		writing = true
+		buf     []byte
+		n       int
+	)
+
 	// Allocate a buffer with `chunkSize` as the capacity
 	// and length (making a 0 array of the size of `chunkSize`)
 	buf = make([]byte, chunkSize)
 	for writing {
 		// put as many bytes as `chunkSize` into the
 		// buf array.
-		n, err = file.Read(buf)
+		n, err = r.Read(buf)
 		if err != nil {
 			if err == io.EOF {
 				writing = false
@@ -82,19 +336,17 @@ func SendFile(
 			}
 
 			err = errors.Wrapf(err,
-				"errored while copying from file to buf")
+				"errored while copying from reader to buf")
 			return
 		}
 
-		err = stream.Send(&Chunk{
-			// because we might've read less than
-			// `chunkSize` we want to only send up to
-			// `n` (amount of bytes read).
-			// note: slicing (`:n`) won't copy the
-			// underlying data, so this as fast as taking
-			// a "pointer" to the underlying storage.
-			Content: buf[:n],
-		})
+		// because we might've read less than
+		// `chunkSize` we want to only send up to
+		// `n` (amount of bytes read).
+		// note: slicing (`:n`) won't copy the
+		// underlying data, so this as fast as taking
+		// a "pointer" to the underlying storage.
+		err = send(buf[:n])
 		if err != nil {
 			err = errors.Wrapf(err,
 				"failed to send chunk via stream")
@@ -102,14 +354,5 @@ func SendFile(
 		}
 	}
 
-	file.Close()
-	if toremove {
-		if os.Remove(filename) != nil {
-			err = errors.Wrapf(err,
-				"failed to remove tmp file")
-			return
-		}
-	}
-
 	return
 }
@@ -0,0 +1,165 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC code of each kind of error
+var errorCodes = map[ErrorKind]codes.Code{
+	ErrorKind_ErrorInvalidPdf:        codes.InvalidArgument,
+	ErrorKind_ErrorInvalidOptions:    codes.InvalidArgument,
+	ErrorKind_ErrorEncrypted:         codes.FailedPrecondition,
+	ErrorKind_ErrorTooLarge:          codes.ResourceExhausted,
+	ErrorKind_ErrorWorkerUnavailable: codes.Unavailable,
+	ErrorKind_ErrorTimeout:           codes.DeadlineExceeded,
+	ErrorKind_ErrorInternal:          codes.Internal,
+	ErrorKind_ErrorOverloaded:        codes.ResourceExhausted,
+	ErrorKind_ErrorCorrupted:         codes.DataLoss,
+	ErrorKind_ErrorLimitExceeded:     codes.ResourceExhausted,
+}
+
+// Size of the standard error output kept in an error, the beginning telling what went wrong
+const maxStderrSize = 4 << 10
+
+// Exit codes of pdftotext and pdfinfo
+const (
+	exitOpenPdf     = 1
+	exitPermissions = 3
+)
+
+//NewError function creates a gRPC status error of the given kind.
+func NewError(kind ErrorKind, format string, args ...interface{}) error {
+	return NewCommandError(kind, 0, "", format, args...)
+}
+
+//NewCommandError function creates a gRPC status error of the given kind
+//carrying the exit code and the standard error output of the failed process,
+//truncated to a few KB.
+func NewCommandError(kind ErrorKind, exitCode int, stderr string, format string, args ...interface{}) error {
+	code, ok := errorCodes[kind]
+	if !ok {
+		code = codes.Unknown
+	}
+	msg := fmt.Sprintf(format, args...)
+	if len(stderr) > maxStderrSize {
+		stderr = stderr[:maxStderrSize] + "... (truncated)"
+	}
+	// The detail would not be sent with invalid UTF-8, a rune may have been cut as well
+	stderr = strings.ToValidUTF8(stderr, "\uFFFD")
+
+	details := []proto.Message{
+		&ErrorDetail{
+			Kind:     kind,
+			ExitCode: int32(exitCode),
+			Stderr:   stderr,
+		},
+	}
+	switch kind {
+	case ErrorKind_ErrorInvalidOptions:
+		details = append(details, &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "Options", Description: msg},
+			},
+		})
+	case ErrorKind_ErrorEncrypted:
+		details = append(details, &errdetails.PreconditionFailure{
+			Violations: []*errdetails.PreconditionFailure_Violation{
+				{Type: "ENCRYPTED", Subject: "pdf", Description: msg},
+			},
+		})
+	case ErrorKind_ErrorTooLarge:
+		details = append(details, &errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{
+				{Subject: "pdf", Description: msg},
+			},
+		})
+	case ErrorKind_ErrorWorkerUnavailable:
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: ptypes.DurationProto(time.Second),
+		})
+	}
+
+	st, err := status.New(code, msg).WithDetails(details...)
+	if err != nil {
+		return status.New(code, msg).Err()
+	}
+
+	return st.Err()
+}
+
+//CommandError function turns the failure of a pdftotext or pdfinfo process
+//run with the ctx context into a gRPC status error.
+func CommandError(ctx context.Context, name string, err error, stderr []byte) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return NewError(ErrorKind_ErrorTimeout, "%s took too long", name)
+	case context.Canceled:
+		return status.Errorf(codes.Canceled, "%s is cancelled", name)
+	}
+
+	if execErr, ok := err.(*exec.Error); ok {
+		return NewError(ErrorKind_ErrorInternal, "%s can't be run on this host: %s", name, execErr.Err)
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return NewError(ErrorKind_ErrorInternal, "%s didn't worked: %s", name, err)
+	}
+	if len(stderr) == 0 {
+		stderr = exitErr.Stderr
+	}
+
+	exitCode := exitErr.ExitCode()
+	kind := ErrorKind_ErrorInternal
+	switch {
+	case exitCode == exitPermissions,
+		strings.Contains(string(stderr), "Incorrect password"):
+		kind = ErrorKind_ErrorEncrypted
+	case exitCode == exitOpenPdf:
+		kind = ErrorKind_ErrorInvalidPdf
+	}
+
+	return NewCommandError(kind, exitCode, string(stderr),
+		"%s didn't worked: %s", name, exitErr)
+}
+
+//ErrorDetailOf function returns the detail carried by a gRPC status error, or nil.
+//The error may be wrapped.
+func ErrorDetailOf(err error) *ErrorDetail {
+	st, ok := status.FromError(errors.Cause(err))
+	if !ok {
+		return nil
+	}
+
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*ErrorDetail); ok {
+			return d
+		}
+	}
+
+	return nil
+}
+
+//StatusError function returns the gRPC status error hidden behind the wrapped err,
+//so it reaches the other side with its code and details. Errors without status
+//are considered internal.
+func StatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(errors.Cause(err)); ok {
+		return errors.Cause(err)
+	}
+
+	return NewError(ErrorKind_ErrorInternal, "%s", err)
+}
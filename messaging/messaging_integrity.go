@@ -0,0 +1,74 @@
+package messaging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash/crc32"
+)
+
+// Table of the CRC32C carried by the chunks
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+//ContentChecksum function returns the CRC32C of the content of a chunk.
+func ContentChecksum(content []byte) uint32 {
+	return crc32.Checksum(content, crc32c)
+}
+
+//VerifyChunk function checks the content of the chunk against its CRC32C, if the sender has set it.
+func VerifyChunk(chunk *Chunk) error {
+	if chunk.Crc32C != 0 && chunk.Crc32C != ContentChecksum(chunk.Content) {
+		return NewError(ErrorKind_ErrorCorrupted,
+			"chunk at offset %d is corrupted: its CRC32C doesn't match", chunk.Offset)
+	}
+
+	return nil
+}
+
+//IsTrailer function tells whether the chunk is the trailer ending a file.
+func IsTrailer(chunk *Chunk) bool {
+	return len(chunk.Sha256) != 0
+}
+
+//VerifyTrailer function checks the SHA-256 digest carried by the trailer
+//against the digest of the received file.
+func VerifyTrailer(trailer *Chunk, digest []byte) error {
+	if !bytes.Equal(trailer.Sha256, digest) {
+		return NewError(ErrorKind_ErrorCorrupted,
+			"file is corrupted: its SHA-256 digest doesn't match")
+	}
+
+	return nil
+}
+
+//VerifyTextDigest function checks the SHA-256 digest carried by the last chunk
+//of a text against the digest of the received text.
+func VerifyTextDigest(last *TextChunk, digest []byte) error {
+	if !bytes.Equal(last.Sha256, digest) {
+		return NewError(ErrorKind_ErrorCorrupted,
+			"text is corrupted: its SHA-256 digest doesn't match")
+	}
+
+	return nil
+}
+
+//TextChecksum function returns the SHA-256 digest of the text of the response,
+//or of the text of its pages put together.
+func TextChecksum(res *TextAndStatus) []byte {
+	h := sha256.New()
+	h.Write(res.Text)
+	for _, page := range res.Pages {
+		h.Write(page.Text)
+	}
+
+	return h.Sum(nil)
+}
+
+//VerifyText function checks the text of the response against its checksum, if the sender has set it.
+func VerifyText(res *TextAndStatus) error {
+	if len(res.TextSha256) != 0 && !bytes.Equal(res.TextSha256, TextChecksum(res)) {
+		return NewError(ErrorKind_ErrorCorrupted,
+			"text is corrupted: its SHA-256 digest doesn't match")
+	}
+
+	return nil
+}
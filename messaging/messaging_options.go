@@ -0,0 +1,181 @@
+package messaging
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Encodings and end-of-line conventions accepted by pdftotext
+var (
+	allowedEncodings = map[string]bool{
+		"UTF-8":        true,
+		"Latin1":       true,
+		"ASCII7":       true,
+		"Symbol":       true,
+		"ZapfDingbats": true,
+		"UCS-2":        true,
+	}
+	allowedEols = map[string]bool{
+		"unix": true,
+		"dos":  true,
+		"mac":  true,
+	}
+)
+
+//SendOptions function sends the extraction options as the first frame of the stream.
+//A nil opts is sent as default options.
+func SendOptions(stream ChunkSender, opts *ExtractionOptions) (err error) {
+	return SendOptionsWithPriority(stream, opts, Priority_PriorityNormal)
+}
+
+//SendOptionsWithPriority function sends the extraction options and the priority of the job
+//as the first frame of the stream. A nil opts is sent as default options.
+func SendOptionsWithPriority(stream ChunkSender, opts *ExtractionOptions, priority Priority) (err error) {
+	return SendUploadOptions(stream, opts, priority, "")
+}
+
+//SendUploadOptions function is SendOptionsWithPriority for a stream continuing
+//the resumable upload of the given id. An empty id means a plain upload.
+func SendUploadOptions(stream ChunkSender, opts *ExtractionOptions, priority Priority, uploadID string) (err error) {
+	if opts == nil {
+		opts = &ExtractionOptions{}
+	}
+
+	err = stream.Send(&Chunk{
+		Options:  opts,
+		Priority: priority,
+		UploadId: uploadID,
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to send extraction options via stream")
+		return
+	}
+
+	return
+}
+
+//ReceiveOptions function reads the first frame of the stream, that must carry the extraction options.
+//The received options are validated before being returned.
+func ReceiveOptions(stream ChunkReceiver) (opts *ExtractionOptions, err error) {
+	opts, _, err = ReceiveOptionsWithPriority(stream)
+
+	return
+}
+
+//ReceiveOptionsWithPriority function is ReceiveOptions also returning the priority of the job.
+func ReceiveOptionsWithPriority(stream ChunkReceiver) (opts *ExtractionOptions, priority Priority, err error) {
+	opts, priority, _, err = ReceiveUploadOptions(stream)
+
+	return
+}
+
+//ReceiveUploadOptions function is ReceiveOptionsWithPriority also returning the id
+//of the resumable upload continued by the stream, if any.
+func ReceiveUploadOptions(stream ChunkReceiver) (opts *ExtractionOptions, priority Priority, uploadID string, err error) {
+	chunk, err := stream.Recv()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed while reading extraction options from stream")
+		return
+	}
+
+	if chunk.Options == nil || len(chunk.Content) != 0 {
+		err = NewError(ErrorKind_ErrorInvalidOptions,
+			"first frame of the stream must carry extraction options only")
+		return
+	}
+
+	if _, ok := Priority_name[int32(chunk.Priority)]; !ok {
+		err = NewError(ErrorKind_ErrorInvalidOptions,
+			"priority %d is unknown", chunk.Priority)
+		return
+	}
+
+	opts = chunk.Options
+	priority = chunk.Priority
+	uploadID = chunk.UploadId
+	err = ValidateOptions(opts)
+	if err != nil {
+		err = NewError(ErrorKind_ErrorInvalidOptions, "%s", err)
+		opts = nil
+		return
+	}
+
+	return
+}
+
+//ValidateOptions function checks the extraction options against the allowed values.
+func ValidateOptions(opts *ExtractionOptions) (err error) {
+	switch {
+	case opts.FirstPage < 0:
+		err = errors.Errorf("first page must be positive, got %d", opts.FirstPage)
+	case opts.LastPage < 0:
+		err = errors.Errorf("last page must be positive, got %d", opts.LastPage)
+	case opts.LastPage != 0 && opts.FirstPage > opts.LastPage:
+		err = errors.Errorf("first page %d is after last page %d", opts.FirstPage, opts.LastPage)
+	case opts.Layout && opts.Raw:
+		err = errors.Errorf("layout and raw modes can't be used together")
+	case opts.Encoding != "" && !allowedEncodings[opts.Encoding]:
+		err = errors.Errorf("encoding %s is not allowed", opts.Encoding)
+	case opts.Eol != "" && !allowedEols[opts.Eol]:
+		err = errors.Errorf("end-of-line convention %s is not allowed", opts.Eol)
+	case opts.Output == OutputMode_OutputPages && opts.NoPageBreaks:
+		err = errors.Errorf("page breaks are needed to split the text by page")
+	}
+
+	return
+}
+
+//PdftotextArgs function translates the extraction options into pdftotext arguments,
+//followed by the pdf and text filenames. Options must be validated beforehand.
+func PdftotextArgs(opts *ExtractionOptions, fn string, txtfn string) (args []string) {
+	if opts.FirstPage != 0 {
+		args = append(args, "-f", strconv.Itoa(int(opts.FirstPage)))
+	}
+	if opts.LastPage != 0 {
+		args = append(args, "-l", strconv.Itoa(int(opts.LastPage)))
+	}
+	if opts.Layout {
+		args = append(args, "-layout")
+	}
+	if opts.Raw {
+		args = append(args, "-raw")
+	}
+	if opts.Encoding != "" {
+		args = append(args, "-enc", opts.Encoding)
+	}
+	if opts.Eol != "" {
+		args = append(args, "-eol", opts.Eol)
+	}
+	if opts.NoPageBreaks {
+		args = append(args, "-nopgbrk")
+	}
+
+	return append(args, fn, txtfn)
+}
+
+//SplitPages function splits the pdftotext output on the form feeds ending each page.
+//The first page is numbered firstPage, or 1 if firstPage is 0.
+func SplitPages(text []byte, firstPage int32) (pages []*Page) {
+	if firstPage == 0 {
+		firstPage = 1
+	}
+
+	parts := bytes.Split(text, []byte{'\f'})
+	// The last page break is followed by nothing
+	if len(parts) > 1 && len(parts[len(parts)-1]) == 0 {
+		parts = parts[:len(parts)-1]
+	}
+
+	for i, part := range parts {
+		pages = append(pages, &Page{
+			Number: firstPage + int32(i),
+			Text:   part,
+		})
+	}
+
+	return
+}
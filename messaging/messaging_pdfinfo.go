@@ -0,0 +1,114 @@
+package messaging
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+)
+
+// Formats of the dates printed by pdfinfo, with and without -isodates
+var pdfinfoDateLayouts = []string{
+	time.RFC3339,
+	"Mon Jan _2 15:04:05 2006 MST",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// Page size line, e.g. "612 x 792 pts (letter)"
+var pdfinfoPageSize = regexp.MustCompile(`^([0-9.]+) x ([0-9.]+) pts(?: \((.+)\))?`)
+
+//PdfinfoArgs function returns the pdfinfo arguments to get the information of the fn file.
+func PdfinfoArgs(fn string) []string {
+	return []string{"-isodates", fn}
+}
+
+//ParsePdfInfo function parses the output of pdfinfo into the document metadata.
+//Unknown or empty fields, as well as dates in an unknown format, are ignored.
+func ParsePdfInfo(out []byte) (meta *PdfMetadata, err error) {
+	meta = &PdfMetadata{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := line[:i]
+		value := strings.TrimSpace(line[i+1:])
+		if value == "" {
+			continue
+		}
+
+		switch key {
+		case "Title":
+			meta.Title = value
+		case "Author":
+			meta.Author = value
+		case "Subject":
+			meta.Subject = value
+		case "Keywords":
+			meta.Keywords = value
+		case "Creator":
+			meta.Creator = value
+		case "Producer":
+			meta.Producer = value
+		case "CreationDate":
+			meta.CreationDate = parsePdfinfoDate(value)
+		case "ModDate":
+			meta.ModDate = parsePdfinfoDate(value)
+		case "PDF version":
+			meta.PdfVersion = value
+		case "Encrypted":
+			meta.Encrypted = strings.HasPrefix(value, "yes")
+		case "Tagged":
+			meta.Tagged = value == "yes"
+		case "Optimized":
+			meta.Optimized = value == "yes"
+		case "Pages":
+			var pages int
+			pages, err = strconv.Atoi(value)
+			meta.Pages = int32(pages)
+		case "File size":
+			meta.FileSize, err = strconv.ParseInt(strings.TrimSuffix(value, " bytes"), 10, 64)
+		case "Page size":
+			m := pdfinfoPageSize.FindStringSubmatch(value)
+			if m == nil {
+				err = errors.Errorf("unknown page size format")
+				break
+			}
+			meta.PageWidth, _ = strconv.ParseFloat(m[1], 64)
+			meta.PageHeight, _ = strconv.ParseFloat(m[2], 64)
+			meta.PageFormat = m[3]
+		}
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to parse pdfinfo line %q",
+				line)
+			return nil, err
+		}
+	}
+
+	return meta, scanner.Err()
+}
+
+func parsePdfinfoDate(value string) (ts *timestamp.Timestamp) {
+	for _, layout := range pdfinfoDateLayouts {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		ts, err = ptypes.TimestampProto(t)
+		if err == nil {
+			return ts
+		}
+	}
+
+	return nil
+}
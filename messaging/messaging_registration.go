@@ -0,0 +1,39 @@
+package messaging
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RegistrationTokenKey is the metadata carrying the token a worker registers with
+const RegistrationTokenKey = "registration-token"
+
+//WithRegistrationToken function returns the context of a call to the admin service
+//giving the registration token of the worker.
+func WithRegistrationToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, RegistrationTokenKey, token)
+}
+
+//CheckRegistrationToken function returns an error unless the call of ctx gives the token.
+//Without token, the workers can't register at all.
+func CheckRegistrationToken(ctx context.Context, token string) error {
+	if token == "" {
+		return status.Errorf(codes.PermissionDenied, "registration of workers is disabled on this server")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(RegistrationTokenKey)
+	if len(values) == 0 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) != 1 {
+		return status.Errorf(codes.Unauthenticated, "registration token is missing or wrong")
+	}
+
+	return nil
+}
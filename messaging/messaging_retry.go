@@ -0,0 +1,77 @@
+package messaging
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterKey is the trailer telling an overloaded client when to try again, in seconds
+const RetryAfterKey = "retry-after"
+
+//NewOverloadedError function creates a ResourceExhausted status error
+//telling the client to try again after the retryAfter delay.
+func NewOverloadedError(retryAfter time.Duration, format string, args ...interface{}) error {
+	err := NewError(ErrorKind_ErrorOverloaded, format, args...)
+	st, _ := status.FromError(err)
+
+	withDelay, derr := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(retryAfter),
+	})
+	if derr != nil {
+		return err
+	}
+
+	return withDelay.Err()
+}
+
+//RetryAfterTrailer function returns the trailer carrying the retry delay of err, if any.
+func RetryAfterTrailer(err error) metadata.MD {
+	delay, ok := RetryDelayOf(err)
+	if !ok {
+		return nil
+	}
+
+	// Whole seconds, as with the HTTP header
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	return metadata.Pairs(RetryAfterKey, strconv.FormatInt(seconds, 10))
+}
+
+//RetryDelayOf function returns the delay after which an overloaded server
+//can be called again, read from the RetryInfo detail of err.
+func RetryDelayOf(err error) (delay time.Duration, ok bool) {
+	st, isStatus := status.FromError(errors.Cause(err))
+	if !isStatus || st.Code() != codes.ResourceExhausted {
+		return
+	}
+
+	for _, detail := range st.Details() {
+		if info, isInfo := detail.(*errdetails.RetryInfo); isInfo {
+			delay, derr := ptypes.Duration(info.RetryDelay)
+			return delay, derr == nil
+		}
+	}
+
+	return
+}
+
+//ParseRetryAfter function reads the retry delay from the trailer of a call.
+func ParseRetryAfter(md metadata.MD) (delay time.Duration, ok bool) {
+	values := md.Get(RetryAfterKey)
+	if len(values) == 0 {
+		return
+	}
+
+	seconds, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || seconds < 0 {
+		return
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
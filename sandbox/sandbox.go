@@ -0,0 +1,258 @@
+// Package sandbox runs the processes reading untrusted pdf files, on the workers and on the server.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// Sandbox runs the extractors and pdfinfo on untrusted files: each request gets a private
+// directory, and the processes are limited and may run as an unprivileged user.
+// The text is limited to the file size limit, whether it is written in a file or not.
+type Sandbox struct {
+	// parent of the directories of the requests
+	tmpDir string
+	// limits of each process, 0 for none
+	cpu       time.Duration
+	memory    int64
+	fileSize  int64
+	openFiles int
+	// user and group the processes run as, -1 to keep the ones of the caller
+	uid int
+	gid int
+}
+
+// New returns the sandbox of the processes, whose request directories are created in tmpDir.
+// runAs is a user name or uid[:gid], empty to keep the user of the caller.
+func New(tmpDir string, cpu time.Duration, memory int64, fileSize int64, openFiles int, runAs string) (sb *Sandbox, err error) {
+	sb = &Sandbox{
+		tmpDir:    tmpDir,
+		cpu:       cpu,
+		memory:    memory,
+		fileSize:  fileSize,
+		openFiles: openFiles,
+		uid:       -1,
+		gid:       -1,
+	}
+	if runAs == "" {
+		return
+	}
+
+	if !canRunAs {
+		return nil, errors.Errorf("running as user %s is not supported on this system", runAs)
+	}
+	sb.uid, sb.gid, err = lookupUser(runAs)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// lookupUser returns the ids of the user given by name or as uid[:gid].
+// The group of a user given by uid alone is the group of the same id.
+func lookupUser(runAs string) (uid int, gid int, err error) {
+	ids := strings.SplitN(runAs, ":", 2)
+	if uid, err = strconv.Atoi(ids[0]); err == nil {
+		gid = uid
+		if len(ids) == 2 {
+			gid, err = strconv.Atoi(ids[1])
+		}
+		if err != nil || uid < 0 || gid < 0 {
+			return 0, 0, errors.Errorf("user %s is not valid: ids must be positive numbers", runAs)
+		}
+		return
+	}
+
+	u, err := user.Lookup(runAs)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to find user %s",
+			runAs)
+		return
+	}
+	uid, err = strconv.Atoi(u.Uid)
+	if err == nil {
+		gid, err = strconv.Atoi(u.Gid)
+	}
+	if err != nil {
+		err = errors.Errorf("user %s has no numeric ids", runAs)
+	}
+
+	return
+}
+
+// limited tells whether the processes have any limit.
+func (sb *Sandbox) limited() bool {
+	return sb.cpu != 0 || sb.memory != 0 || sb.fileSize != 0 || sb.openFiles != 0
+}
+
+// JobDir creates the private directory of a request, given to the user running
+// the processes. The returned cleanup removes it with its files.
+func (sb *Sandbox) JobDir() (dir string, cleanup func(), err error) {
+	dir, err = ioutil.TempDir(sb.tmpDir, "job")
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create job directory in %s",
+			sb.tmpDir)
+		return
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	if sb.uid >= 0 {
+		err = os.Chown(dir, sb.uid, sb.gid)
+		if err != nil {
+			cleanup()
+			err = errors.Wrapf(err,
+				"failed to give job directory %s to user %d",
+				dir, sb.uid)
+			return "", nil, err
+		}
+	}
+
+	return dir + string(filepath.Separator), cleanup, nil
+}
+
+// Command returns the name process run in the dir directory of a request with the
+// limits of the sandbox. Its temporary files are written in the same directory.
+func (sb *Sandbox) Command(ctx context.Context, dir string, name string, args ...string) *exec.Cmd {
+	cmd := sb.limitedCommand(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "TMPDIR="+dir, "HOME="+dir)
+
+	return cmd
+}
+
+// CommandError is messaging.CommandError, telling the processes stopped by a limit
+// of the sandbox apart from the other failures.
+func (sb *Sandbox) CommandError(ctx context.Context, name string, err error, stderr []byte) error {
+	if ctx.Err() != nil || !sb.limited() {
+		return messaging.CommandError(ctx, name, err, stderr)
+	}
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return messaging.CommandError(ctx, name, err, stderr)
+	}
+	if len(stderr) == 0 {
+		stderr = exitErr.Stderr
+	}
+
+	if sig, ok := sb.limitSignal(exitErr); ok {
+		return messaging.NewCommandError(messaging.ErrorKind_ErrorLimitExceeded, exitErr.ExitCode(), string(stderr),
+			"%s exceeded a limit of the sandbox: %s", name, sig)
+	}
+	if sb.memory != 0 && outOfMemory(stderr) {
+		return messaging.NewCommandError(messaging.ErrorKind_ErrorLimitExceeded, exitErr.ExitCode(), string(stderr),
+			"%s exceeded the memory limit of the sandbox", name)
+	}
+
+	return messaging.CommandError(ctx, name, err, stderr)
+}
+
+// Runner returns the runner of the processes of the request having the dir directory.
+func (sb *Sandbox) Runner(dir string) extractor.Runner {
+	return jobRunner{sb: sb, dir: dir}
+}
+
+// jobRunner runs the processes of an extractor in the directory of a request.
+type jobRunner struct {
+	sb  *Sandbox
+	dir string
+}
+
+func (r jobRunner) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return r.sb.Command(ctx, r.dir, name, args...)
+}
+
+func (r jobRunner) Error(ctx context.Context, name string, err error, stderr []byte) error {
+	return r.sb.CommandError(ctx, name, err, stderr)
+}
+
+// Failure of a write beyond the file size limit
+var errOutputLimit = errors.New("text exceeds the file size limit")
+
+// LimitedWriter writes into w up to limit bytes, 0 for no limit.
+type LimitedWriter struct {
+	w        io.Writer
+	limit    int64
+	written  int64
+	exceeded bool
+}
+
+// Output returns w limited to the file size limit of the sandbox.
+func (sb *Sandbox) Output(w io.Writer) *LimitedWriter {
+	return &LimitedWriter{w: w, limit: sb.fileSize}
+}
+
+func (lw *LimitedWriter) Write(p []byte) (n int, err error) {
+	if lw.limit != 0 && lw.written+int64(len(p)) > lw.limit {
+		lw.exceeded = true
+		return 0, errOutputLimit
+	}
+	n, err = lw.w.Write(p)
+	lw.written += int64(n)
+
+	return
+}
+
+// OutputError returns the error of an extraction writing into lw: the extractor
+// stopped by a write beyond the limit fails with whatever error, it is told here.
+func (sb *Sandbox) OutputError(lw *LimitedWriter, err error) error {
+	if lw.exceeded {
+		return messaging.NewError(messaging.ErrorKind_ErrorLimitExceeded,
+			"text exceeded the file size limit of the sandbox: %d bytes", sb.fileSize)
+	}
+
+	return err
+}
+
+// outOfMemory tells whether the process failed to allocate memory.
+func outOfMemory(stderr []byte) bool {
+	for _, msg := range []string{"Out of memory", "bad_alloc", "Cannot allocate memory"} {
+		if strings.Contains(string(stderr), msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Limits describes the limits of the sandbox for the logs.
+func (sb *Sandbox) Limits() string {
+	if !sb.limited() && sb.uid < 0 {
+		return "no limit"
+	}
+
+	var limits []string
+	if sb.cpu != 0 {
+		limits = append(limits, fmt.Sprintf("%s of CPU", sb.cpu))
+	}
+	if sb.memory != 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes of memory", sb.memory))
+	}
+	if sb.fileSize != 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes per file", sb.fileSize))
+	}
+	if sb.openFiles != 0 {
+		limits = append(limits, fmt.Sprintf("%d open files", sb.openFiles))
+	}
+	if sb.uid >= 0 {
+		limits = append(limits, fmt.Sprintf("run as %d:%d", sb.uid, sb.gid))
+	}
+
+	return strings.Join(limits, ", ")
+}
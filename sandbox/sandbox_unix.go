@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The processes can be given to another user
+const canRunAs = true
+
+// limitedCommand runs the name process through a shell applying the limits
+// with ulimit, then replacing itself with the process. The shell is POSIX,
+// so file sizes are in 512-byte blocks and memory in kilobytes.
+// The process is looked up first: the shell would only exit with status 127
+// if it is missing, while the returned command fails to start with an *exec.Error.
+func (sb *Sandbox) limitedCommand(ctx context.Context, name string, args ...string) (cmd *exec.Cmd) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return exec.CommandContext(ctx, name, args...)
+	}
+
+	var ulimits []string
+	if sb.cpu != 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", sb.cpuLimit()/time.Second))
+	}
+	if sb.memory != 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", (sb.memory+1023)/1024))
+	}
+	if sb.fileSize != 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -f %d", (sb.fileSize+511)/512))
+	}
+	if sb.openFiles != 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", sb.openFiles))
+	}
+
+	if len(ulimits) == 0 {
+		cmd = exec.CommandContext(ctx, path, args...)
+	} else {
+		script := strings.Join(append(ulimits, `exec "$0" "$@"`), " && ")
+		cmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script, path}, args...)...)
+	}
+	if sb.uid >= 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: uint32(sb.uid),
+				Gid: uint32(sb.gid),
+			},
+		}
+	}
+
+	return
+}
+
+// cpuLimit returns the CPU limit rounded up to the second, as given to ulimit.
+func (sb *Sandbox) cpuLimit() time.Duration {
+	return (sb.cpu + time.Second - 1) / time.Second * time.Second
+}
+
+// limitSignal returns the signal that killed the process, if the kernel sent it because
+// of a limit of the sandbox. ulimit sets the soft and hard CPU limits at once, so a process
+// reaching them gets SIGKILL rather than SIGXCPU: the same signal is sent by the OOM killer
+// or on cancellation, it only tells the limit if the process used up its CPU time, give
+// or take the accuracy of the accounting.
+func (sb *Sandbox) limitSignal(exitErr *exec.ExitError) (sig os.Signal, ok bool) {
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return nil, false
+	}
+
+	sig = ws.Signal()
+	switch sig {
+	case syscall.SIGXCPU:
+		return sig, sb.cpu != 0
+	case syscall.SIGXFSZ:
+		return sig, sb.fileSize != 0
+	case syscall.SIGKILL:
+		used := exitErr.UserTime() + exitErr.SystemTime()
+		return sig, sb.cpu != 0 && used >= sb.cpuLimit()*9/10
+	}
+
+	return nil, false
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// The processes run as the user of the caller
+const canRunAs = false
+
+// limitedCommand runs the name process without limits, as there is no ulimit.
+func (sb *Sandbox) limitedCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// limitSignal never finds a limit, as none is applied.
+func (sb *Sandbox) limitSignal(exitErr *exec.ExitError) (sig os.Signal, ok bool) {
+	return nil, false
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/ptypes"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// ListWorkers implements ListWorkers method of PdftotextAdmin.
+func (s *ServerGRPC) ListWorkers(ctx context.Context, req *messaging.ListWorkersRequest) (list *messaging.WorkerList, err error) {
+	// The weights are updated by registrations
+	s.workermtx.RLock()
+	defer s.workermtx.RUnlock()
+
+	list = &messaging.WorkerList{
+		QueueLength: int32(s.queue.len()),
+		QueueSize:   int32(s.queue.size),
+	}
+	for _, w := range s.workers {
+		list.Workers = append(list.Workers, w.info())
+	}
+
+	return
+}
+
+// GetJobStats implements GetJobStats method of PdftotextAdmin.
+func (s *ServerGRPC) GetJobStats(ctx context.Context, req *messaging.JobStatsRequest) (stats *messaging.JobStats, err error) {
+	stats = &messaging.JobStats{
+		Jobs:       make(map[string]int64),
+		Expired:    atomic.LoadInt64(&s.expiredJobs),
+		Forgotten:  atomic.LoadInt64(&s.forgottenJobs),
+		CacheBytes: s.cache.used(),
+		ResultTtl:  ptypes.DurationProto(s.retention.result),
+		FailureTtl: ptypes.DurationProto(s.retention.failure),
+		ExpiredTtl: ptypes.DurationProto(s.retention.expired),
+	}
+	for _, j := range s.jobs() {
+		stats.Jobs[j.status().State.String()]++
+		stats.Bytes += j.ws.size()
+	}
+
+	return
+}
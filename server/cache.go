@@ -0,0 +1,353 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//CheckHash implements CheckHash method of PdftotextService. If the text extracted
+//from the file of the given hash is in the cache, a job answered by the cache is given
+//and the file doesn't need to be uploaded. The clients checking the same hash share
+//the job. Without extractor, the text of pdftotext is looked up, as the server would run it.
+//A server without cache answers FailedPrecondition, so the clients stop hashing their files.
+func (s *ServerGRPC) CheckHash(ctx context.Context, hash *messaging.FileHash) (st *messaging.HashStatus, err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
+
+	if len(hash.Sha256) != sha256.Size {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"a SHA-256 digest is %d bytes long, got %d", sha256.Size, len(hash.Sha256))
+	}
+	opts := hash.Options
+	if opts == nil {
+		opts = &messaging.ExtractionOptions{}
+	}
+	if err = messaging.ValidateOptions(opts); err != nil {
+		return nil, messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions, "%s", err)
+	}
+	if err = extractor.Check(opts); err != nil {
+		return
+	}
+
+	if s.cache == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "texts are not cached by this server")
+	}
+	key, err := keyOf(hash.Sha256, hash.Size, resolvedOptions(opts, extractor.Poppler))
+	if err != nil {
+		return
+	}
+
+	st = &messaging.HashStatus{}
+	if j := s.cachedJobOf(key); j != nil {
+		st.Known = true
+		st.Uuid = j.uuid
+		return
+	}
+	uuid := uuid.New().String()
+	ws := s.newWorkspace(uuid)
+	if s.cachedJob(uuid, ws, key, opts) {
+		st.Known = true
+		st.Uuid = uuid
+	} else {
+		ws.release()
+	}
+
+	return
+}
+
+// cachedJob creates a job done with the text of the key if it is in the cache,
+// the job taking over the workspace. It returns false on a miss.
+func (s *ServerGRPC) cachedJob(uuid string, ws *workspace, key string, opts *messaging.ExtractionOptions) bool {
+	if !s.cache.get(key, ws.txtfn) {
+		return false
+	}
+
+	j, _ := newJob(uuid, ws, opts, messaging.Priority_PriorityNormal, time.Time{}, s.store)
+	j.cacheKey = key
+	j.cached = true
+	j.finish(ws.txtfn, nil)
+	s.reqmtx.Lock()
+	s.requests[uuid] = j
+	s.cachedJobs[key] = j
+	s.reqmtx.Unlock()
+	s.logger.Info().Msg(fmt.Sprintf("%s: the text is in the cache", uuid))
+
+	return true
+}
+
+// cachedJobOf returns the job answered by the cache with the text of the key,
+// if it is still kept and its text can be given.
+func (s *ServerGRPC) cachedJobOf(key string) *job {
+	s.reqmtx.RLock()
+	j := s.cachedJobs[key]
+	known := j != nil && s.requests[j.uuid] == j
+	s.reqmtx.RUnlock()
+
+	if !known {
+		return nil
+	}
+	if state, _ := j.age(); state != messaging.JobState_JobDone {
+		return nil
+	}
+
+	return j
+}
+
+// cacheEntry is a text of the cache, named after its key
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// resultCache keeps the extracted texts on the disk, by content of the pdf
+// and extraction options. The least recently used texts are evicted once
+// the budget is exceeded. A nil resultCache doesn't keep anything.
+type resultCache struct {
+	logger zerolog.Logger
+	dir    string
+	// maximum size of the texts, in bytes
+	budget int64
+	size   int64
+	// most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+	hits    int64
+	misses  int64
+	mtx     *sync.Mutex
+}
+
+// openResultCache returns the cache of the texts stored in dir, that are
+// ordered by time of last use. A zero budget disables the cache.
+func openResultCache(dir string, budget int64, logger zerolog.Logger) (c *resultCache, err error) {
+	if budget == 0 {
+		return nil, nil
+	}
+
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create cache folder %s",
+			dir)
+		return
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to read cache folder %s",
+			dir)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	c = &resultCache{
+		logger:  logger,
+		dir:     dir,
+		budget:  budget,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		mtx:     &sync.Mutex{},
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		c.entries[info.Name()] = c.lru.PushBack(&cacheEntry{
+			key:  info.Name(),
+			size: info.Size(),
+		})
+		c.size += info.Size()
+	}
+	c.evict()
+
+	return
+}
+
+// key returns the key of the text extracted from the pdffn file with opts.
+func (c *resultCache) key(pdffn string, opts *messaging.ExtractionOptions) (key string, err error) {
+	if c == nil {
+		return
+	}
+
+	hash, err := messaging.HashFile(pdffn)
+	if err != nil {
+		return
+	}
+
+	return keyOf(hash.Sha256, hash.Size, opts)
+}
+
+// resolvedOptions returns the options naming the extractor that runs them,
+// the name one if they don't name any.
+func resolvedOptions(opts *messaging.ExtractionOptions, name string) *messaging.ExtractionOptions {
+	if opts.Extractor != "" {
+		return opts
+	}
+
+	opts = proto.Clone(opts).(*messaging.ExtractionOptions)
+	opts.Extractor = name
+
+	return opts
+}
+
+// keyOf returns the key of the text extracted with opts from the pdf of the given digest
+// and size. The options must name the extractor, as each one gives its own text. Those
+// only changing the shape of the response are ignored, as they don't change the text.
+func keyOf(digest []byte, size int64, opts *messaging.ExtractionOptions) (key string, err error) {
+	if opts.Extractor == "" {
+		return "", errors.Errorf("extractor of the options must be known to cache the text")
+	}
+	textOpts := proto.Clone(opts).(*messaging.ExtractionOptions)
+	textOpts.Output = messaging.OutputMode_OutputPlain
+	textOpts.IncludeMetadata = false
+	encoded, err := proto.Marshal(textOpts)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to encode extraction options")
+		return
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%x %d ", digest, size)
+	h.Write(encoded)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// get gives the cached text of the key to txtfn. It returns false on a miss.
+func (c *resultCache) get(key string, txtfn string) bool {
+	if c == nil || key == "" {
+		return false
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e, ok := c.entries[key]
+	if ok {
+		fn := c.dir + key
+		if err := linkFile(fn, txtfn); err != nil {
+			c.logger.Error().Err(err).Msg("failed to read from the cache")
+			c.remove(e)
+			ok = false
+		} else {
+			c.lru.MoveToFront(e)
+			now := time.Now()
+			os.Chtimes(fn, now, now)
+		}
+	}
+
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.logger.Debug().Msg(fmt.Sprintf("cache: %d hits, %d misses, %d bytes used",
+		c.hits, c.misses, c.size))
+
+	return ok
+}
+
+// put adds the txtfn text under the key, evicting older texts if needed.
+func (c *resultCache) put(key string, txtfn string) {
+	if c == nil || key == "" {
+		return
+	}
+
+	info, err := os.Stat(txtfn)
+	if err != nil || info.Size() > c.budget {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	if err = linkFile(txtfn, c.dir+key); err != nil {
+		c.logger.Error().Err(err).Msg("failed to write into the cache")
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:  key,
+		size: info.Size(),
+	})
+	c.size += info.Size()
+	c.evict()
+}
+
+// used returns the size of the cached texts.
+func (c *resultCache) used() int64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.size
+}
+
+// evict removes the least recently used texts until the budget is respected.
+// It must be called with the cache lock held.
+func (c *resultCache) evict() {
+	for c.size > c.budget {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove must be called with the cache lock held.
+func (c *resultCache) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	os.Remove(c.dir + entry.key)
+	c.lru.Remove(e)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// linkFile makes dst a hard link to src, or a copy if links are not supported.
+func linkFile(src string, dst string) (err error) {
+	if os.Link(src, dst) == nil {
+		return
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+
+	return
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+func TestKeyOf(t *testing.T) {
+	digest := make([]byte, 32)
+	key := func(opts *messaging.ExtractionOptions) string {
+		k, err := keyOf(digest, 100, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	poppler := key(&messaging.ExtractionOptions{Extractor: extractor.Poppler})
+	tests := []struct {
+		name string
+		opts *messaging.ExtractionOptions
+		same bool
+	}{
+		{"go extractor", &messaging.ExtractionOptions{Extractor: extractor.Go}, false},
+		{"mutool extractor", &messaging.ExtractionOptions{Extractor: extractor.Mutool}, false},
+		{"layout", &messaging.ExtractionOptions{Extractor: extractor.Poppler, Layout: true}, false},
+		{"first page", &messaging.ExtractionOptions{Extractor: extractor.Poppler, FirstPage: 2}, false},
+		{"per-page output", &messaging.ExtractionOptions{Extractor: extractor.Poppler, Output: messaging.OutputMode_OutputPages}, true},
+		{"metadata", &messaging.ExtractionOptions{Extractor: extractor.Poppler, IncludeMetadata: true}, true},
+		{"default extractor", resolvedOptions(&messaging.ExtractionOptions{}, extractor.Poppler), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := key(test.opts) == poppler; same != test.same {
+				t.Errorf("key is the same as with pdftotext: %t, expected %t", same, test.same)
+			}
+		})
+	}
+
+	if _, err := keyOf(digest, 100, &messaging.ExtractionOptions{}); err == nil {
+		t.Error("key of options without extractor is computed")
+	}
+	if other, _ := keyOf(digest, 101, &messaging.ExtractionOptions{Extractor: extractor.Poppler}); other == poppler {
+		t.Error("files of different sizes have the same key")
+	}
+}
+
+// testCache returns an empty cache of the given budget, and the directory of the texts given to it.
+func testCache(t *testing.T, budget int64) (c *resultCache, dir string) {
+	t.Helper()
+
+	dir = t.TempDir() + string(filepath.Separator)
+	c, err := openResultCache(dir+"cache"+string(filepath.Separator), budget, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, dir
+}
+
+// writeText writes a text of the given size into fn.
+func writeText(t *testing.T, fn string, size int) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(fn, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	c, dir := testCache(t, 100)
+	for _, key := range []string{"a", "b"} {
+		writeText(t, dir+key, 40)
+		c.put(key, dir+key)
+	}
+	// a is used again, b is the least recently used one
+	if !c.get("a", dir+"a.txt") {
+		t.Fatal("a is not cached")
+	}
+	writeText(t, dir+"c", 40)
+	c.put("c", dir+"c")
+
+	for key, cached := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.entries[key]; ok != cached {
+			t.Errorf("%s is cached: %t, expected %t", key, ok, cached)
+		}
+		if _, err := os.Stat(c.dir + key); (err == nil) != cached {
+			t.Errorf("file of %s is kept: %t, expected %t", key, err == nil, cached)
+		}
+	}
+	if used := c.used(); used != 80 {
+		t.Errorf("cache uses %d bytes, expected 80", used)
+	}
+
+	// A bigger text evicts as many texts as needed
+	writeText(t, dir+"d", 90)
+	c.put("d", dir+"d")
+	if len(c.entries) != 1 || c.used() != 90 {
+		t.Errorf("cache has %d texts of %d bytes, expected d alone", len(c.entries), c.used())
+	}
+}
+
+func TestResultCacheOversized(t *testing.T) {
+	c, dir := testCache(t, 100)
+	writeText(t, dir+"a", 60)
+	c.put("a", dir+"a")
+
+	writeText(t, dir+"big", 101)
+	c.put("big", dir+"big")
+	if _, ok := c.entries["big"]; ok {
+		t.Error("text larger than the cache is cached")
+	}
+	if _, ok := c.entries["a"]; !ok || c.used() != 60 {
+		t.Error("text larger than the cache evicts the other ones")
+	}
+}
+
+func TestResultCacheLinks(t *testing.T) {
+	c, dir := testCache(t, 100)
+	writeText(t, dir+"a.txt", 10)
+	c.put("a", dir+"a.txt")
+
+	// The cache and the jobs share the texts
+	if !c.get("a", dir+"b.txt") {
+		t.Fatal("a is not cached")
+	}
+	cached, err := os.Stat(c.dir + "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{dir + "a.txt", dir + "b.txt"} {
+		info, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(info, cached) {
+			t.Errorf("%s is not linked to the cached text", fn)
+		}
+	}
+
+	// The text outlives the job that put it
+	os.Remove(dir + "a.txt")
+	if !c.get("a", dir+"c.txt") {
+		t.Error("text is lost with the file of the job")
+	}
+
+	// A cached text that is gone is a miss, and is forgotten
+	os.Remove(c.dir + "a")
+	if c.get("a", dir+"d.txt") {
+		t.Error("text removed from the disk is a hit")
+	}
+	if _, ok := c.entries["a"]; ok || c.used() != 0 {
+		t.Error("text removed from the disk is still cached")
+	}
+}
+
+func TestResultCacheReopen(t *testing.T) {
+	c, dir := testCache(t, 100)
+	for i, key := range []string{"a", "b", "c"} {
+		writeText(t, dir+key, 40)
+		c.put(key, dir+key)
+		// The texts are ordered by time of last use
+		at := time.Now().Add(time.Duration(i-3) * time.Minute)
+		os.Chtimes(c.dir+key, at, at)
+	}
+
+	// The oldest texts are evicted by a smaller budget
+	reopened, err := openResultCache(c.dir, 50, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.entries) != 1 || reopened.entries["c"] == nil {
+		t.Errorf("cache kept %d texts, expected c alone", len(reopened.entries))
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	c, dir := testCache(t, 100)
+	writeText(t, dir+"a.pdf", 10)
+	writeText(t, dir+"b.pdf", 11)
+	opts := &messaging.ExtractionOptions{Extractor: extractor.Poppler}
+
+	a, err := c.key(dir+"a.pdf", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.key(dir+"b.pdf", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("different files have the same key")
+	}
+	layout, err := c.key(dir+"a.pdf", &messaging.ExtractionOptions{Extractor: extractor.Poppler, Layout: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutool, err := c.key(dir+"a.pdf", &messaging.ExtractionOptions{Extractor: extractor.Mutool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout == a || mutool == a || layout == mutool {
+		t.Error("texts of different options or extractors have the same key")
+	}
+
+	var disabled *resultCache
+	if key, err := disabled.key(dir+"a.pdf", opts); key != "" || err != nil {
+		t.Errorf("disabled cache gives key %q, %v", key, err)
+	}
+}
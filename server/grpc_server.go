@@ -9,21 +9,27 @@ import (
 	"os/exec"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
 	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"gitlab.com/gaydamakha/ter-grpc/sandbox"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 
 	_ "google.golang.org/grpc/encoding/gzip"
 )
 
-type workerRequest struct {
-	txtfn string
-	err   error
-}
+// Size of the texts sent in a single message by UploadPdfAndGetText, leaving room
+// for the rest of the message within the default 4MB limit of the gRPC clients
+const maxTextMessageSize = 4<<20 - 64<<10
 
 type ServerGRPC struct {
 	logger         zerolog.Logger
@@ -33,14 +39,46 @@ type ServerGRPC struct {
 	key            string
 	chunkSize      int
 	compress       bool
-	workers        []workerClientGRPC
-	workerCount    int
+	workers        []*workerClientGRPC
 	workermtx      *sync.RWMutex
-	nbWorkers      int
+	scheduler      Scheduler
 	incomingFolder string
 	outgoingFolder string
-	requests       map[string]chan workerRequest
+	sandboxFolder  string
+	requests       map[string]*job
+	// jobs answered by the cache, by key, given again to the clients checking the same hash
+	cachedJobs     map[string]*job
 	reqmtx         *sync.RWMutex
+	store          *jobStore
+	queue          *jobQueue
+	maxFileSize    int64
+	health         *health.Server
+	healthInterval time.Duration
+	// consecutive failed probes before a worker is ejected
+	unhealthyThreshold int
+	retry              retryPolicy
+	heartbeatTimeout   time.Duration
+	// token the workers give to register, none to refuse them
+	registrationToken string
+	// signaled when a worker has room for a new request
+	freed      chan struct{}
+	retryAfter time.Duration
+	// requests given at most at once to a worker given at startup
+	workerConcurrency int
+	// pages from which a document is split across the workers, 0 for never
+	splitMinPages int
+	cache         *resultCache
+	// resumable uploads having a stream in progress
+	uploading map[string]bool
+	uploadmtx *sync.Mutex
+	retention retentionPolicy
+	// time given at most to a request, from its upload
+	maxProcessingTime time.Duration
+	// runs pdfinfo and the extractors on the server
+	sandbox *sandbox.Sandbox
+	// results expired and jobs forgotten by the janitor or deleted
+	expiredJobs   int64
+	forgottenJobs int64
 }
 
 type ServerGRPCConfig struct {
@@ -49,7 +87,61 @@ type ServerGRPCConfig struct {
 	Port        int
 	ChunkSize   int
 	Compress    bool
-	AdWorkers   []string
+	// Addresses of the workers, followed by @weight for weighted schedulers.
+	// More workers can register themselves later.
+	AdWorkers []string
+	// Name of the scheduler picking the workers, round-robin by default
+	Scheduler string
+	// Maximum size of uploaded files in bytes, 0 for no limit
+	MaxFileSize int64
+	// Delay between two checks of the workers health
+	HealthInterval time.Duration
+	// Number of consecutive failed checks before a worker stops receiving jobs
+	UnhealthyThreshold int
+	// Number of workers a job is dispatched to before failing, 1 for no retry
+	MaxAttempts int
+	// Delay before dispatching a job again, doubled at each retry up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Delay after which a registered worker without heartbeat is dropped
+	HeartbeatTimeout time.Duration
+	// Token the workers must give to register, empty to refuse any registration
+	RegistrationToken string
+	// Journal of the jobs surviving restarts, empty to keep them in memory only
+	JobJournal string
+	// Maximum number of jobs of each priority waiting for a worker
+	QueueSize int
+	// Waiting time after which a queued job gets the priority of the level above
+	PriorityAging time.Duration
+	// Requests given at most at once to a worker given at startup,
+	// registered workers announce their capacity
+	WorkerConcurrency int
+	// Delay after which rejected clients are told to try again
+	RetryAfter time.Duration
+	// Number of pages from which an uploaded document is split into page ranges
+	// processed in parallel by the workers, 0 to never split. The pages are
+	// counted by pdfinfo, which must then be installed on the server
+	SplitMinPages int
+	// Disk space of the cache of the extracted texts, in bytes, 0 to disable it
+	CacheSize int64
+	// Maximum time between the upload of a request and its result,
+	// shortened by the deadline of the client. Its deadline is passed
+	// down to the workers, whose own maximum must not be shorter
+	MaxProcessingTime time.Duration
+	// Duration during which the text of a done job can be fetched
+	ResultTTL time.Duration
+	// Duration during which the failure of a failed or cancelled job can be fetched
+	FailureTTL time.Duration
+	// Duration during which the state of an expired job is still given,
+	// after which the server forgets the job
+	ExpiredTTL time.Duration
+	// Limits of each extractor or pdfinfo process run by the server, 0 for none
+	CPULimit       time.Duration
+	MemoryLimit    int64
+	FileSizeLimit  int64
+	OpenFilesLimit int
+	// User the processes run as, by name or uid[:gid], empty to keep the one of the server
+	RunAs string
 }
 
 func NewServerGRPC(cfg ServerGRPCConfig) (s ServerGRPC, err error) {
@@ -79,31 +171,111 @@ func NewServerGRPC(cfg ServerGRPCConfig) (s ServerGRPC, err error) {
 	s.certificate = cfg.Certificate
 	s.key = cfg.Key
 	s.compress = cfg.Compress
-	s.nbWorkers = len(cfg.AdWorkers)
-	s.workerCount = 0
+	s.maxFileSize = cfg.MaxFileSize
+	s.health = health.NewServer()
+	s.healthInterval = cfg.HealthInterval
+	if s.healthInterval == 0 {
+		s.healthInterval = 5 * time.Second
+	}
+	s.unhealthyThreshold = cfg.UnhealthyThreshold
+	if s.unhealthyThreshold == 0 {
+		s.unhealthyThreshold = 3
+	}
+	s.retry = retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     cfg.RetryBackoff,
+		maxBackoff:  cfg.MaxRetryBackoff,
+	}
+	if s.retry.maxAttempts == 0 {
+		s.retry.maxAttempts = 3
+	}
+	if s.retry.backoff == 0 {
+		s.retry.backoff = 200 * time.Millisecond
+	}
+	if s.retry.maxBackoff < s.retry.backoff {
+		s.retry.maxBackoff = s.retry.backoff
+	}
+	s.heartbeatTimeout = cfg.HeartbeatTimeout
+	if s.heartbeatTimeout == 0 {
+		s.heartbeatTimeout = 15 * time.Second
+	}
+	s.registrationToken = cfg.RegistrationToken
+	s.scheduler, err = NewScheduler(cfg.Scheduler)
+	if err != nil {
+		return
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.PriorityAging == 0 {
+		cfg.PriorityAging = 30 * time.Second
+	}
+	s.queue = newJobQueue(cfg.QueueSize, cfg.PriorityAging)
+	s.freed = make(chan struct{}, 1)
+	s.retryAfter = cfg.RetryAfter
+	if s.retryAfter == 0 {
+		s.retryAfter = time.Second
+	}
+	s.workerConcurrency = cfg.WorkerConcurrency
+	if s.workerConcurrency == 0 {
+		s.workerConcurrency = 4
+	}
+	s.splitMinPages = cfg.SplitMinPages
+	if s.splitMinPages > 0 {
+		// The documents are split according to their number of pages, given by pdfinfo
+		if _, err = exec.LookPath("pdfinfo"); err != nil {
+			err = errors.Wrapf(err,
+				"pdfinfo is needed to split the documents")
+			return
+		}
+	}
+	s.maxProcessingTime = cfg.MaxProcessingTime
+	if s.maxProcessingTime == 0 {
+		s.maxProcessingTime = 10 * time.Minute
+	}
+	s.retention = retentionPolicy{
+		result:  cfg.ResultTTL,
+		failure: cfg.FailureTTL,
+		expired: cfg.ExpiredTTL,
+	}
+	if s.retention.result == 0 {
+		s.retention.result = 10 * time.Minute
+	}
+	if s.retention.failure == 0 {
+		s.retention.failure = s.retention.result
+	}
+	if s.retention.expired == 0 {
+		s.retention.expired = time.Hour
+	}
+	s.uploading = make(map[string]bool)
+	s.uploadmtx = &sync.Mutex{}
 	s.incomingFolder = "/tmp/pdftotext/incoming/"
 	s.outgoingFolder = "/tmp/pdftotext/outgoing/"
+	s.sandboxFolder = "/tmp/pdftotext/sandbox/"
 	s.workermtx = &sync.RWMutex{}
 	s.reqmtx = &sync.RWMutex{}
-	s.requests = make(map[string]chan workerRequest)
-
-	if len(cfg.AdWorkers) == 0 {
-		err = errors.Errorf("Workers addresses must be specified")
-	}
+	s.requests = make(map[string]*job)
+	s.cachedJobs = make(map[string]*job)
 
 	for _, adWorker := range cfg.AdWorkers {
+		address, weight, err := parseWorkerAddress(adWorker)
+		if err != nil {
+			return s, err
+		}
 		grpcWorkerClient, err := newWorkerClientGRPC(workerClientGRPCConfig{
-			Address:         adWorker,
+			Address:         address,
+			Weight:          weight,
+			MaxInFlight:     s.workerConcurrency,
 			ChunkSize:       s.chunkSize,
 			RootCertificate: s.certificate,
 			Compress:        s.compress,
 		})
 		if err != nil {
-			//TODO: replace by return?
-			panic(err)
+			return s, errors.Wrapf(err,
+				"failed to add worker %s", address)
 		}
-		s.logger.Info().Msg(fmt.Sprintf("Server successfully added %s as a worker", adWorker))
-		s.workers = append(s.workers, grpcWorkerClient)
+		s.logger.Info().Msg(fmt.Sprintf("Server successfully added %s as a worker", address))
+		s.workers = append(s.workers, &grpcWorkerClient)
 	}
 
 	err = os.MkdirAll(s.incomingFolder, 0777)
@@ -114,6 +286,29 @@ func NewServerGRPC(cfg ServerGRPCConfig) (s ServerGRPC, err error) {
 	if err != nil {
 		return
 	}
+	s.cache, err = openResultCache(s.outgoingFolder+"cache/", cfg.CacheSize, s.logger)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(s.sandboxFolder, 0777)
+	if err != nil {
+		return
+	}
+	s.sandbox, err = sandbox.New(s.sandboxFolder, cfg.CPULimit, cfg.MemoryLimit, cfg.FileSizeLimit, cfg.OpenFilesLimit, cfg.RunAs)
+	if err != nil {
+		return
+	}
+	s.logger.Info().Msg(fmt.Sprintf("pdfinfo and the extractors of the server run with %s", s.sandbox.Limits()))
+
+	if cfg.JobJournal != "" {
+		var records []*jobRecord
+		s.store, records, err = openJobStore(cfg.JobJournal, s.logger)
+		if err != nil {
+			return
+		}
+		s.restoreJobs(records)
+	}
+	s.sweepWorkspaces()
 
 	s.logger.Info().Msg("Server successfully configured")
 
@@ -150,6 +345,12 @@ func (s *ServerGRPC) Listen() (err error) {
 
 	s.server = grpc.NewServer(grpcOpts...)
 	messaging.RegisterPdftotextServiceServer(s.server, s)
+	messaging.RegisterPdftotextAdminServer(s.server, s)
+	healthpb.RegisterHealthServer(s.server, s.health)
+	go s.watchWorkers()
+	go s.dropSilentWorkers()
+	go s.runQueue()
+	go s.janitor()
 
 	s.logger.Info().Msg("Serving...")
 
@@ -166,33 +367,69 @@ func (s *ServerGRPC) Listen() (err error) {
 // interface which is responsible for receiving a stream of
 // chunks that form a complete file.
 func (s *ServerGRPC) UploadPdfAndGetText(stream messaging.PdftotextService_UploadPdfAndGetTextServer) (err error) {
-	uuid := uuid.New().String()
-	fn := s.incomingFolder + "pdftotext" + uuid + ".pdf"
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
+
+	ws := s.newWorkspace(uuid.New().String())
+	fn, txtfn := ws.pdffn, ws.txtfn
 
-	file, err := messaging.ReceiveFile(stream, fn)
+	//Be clean, whatever happens.
+	defer ws.release()
+
+	opts, err := messaging.ReceiveOptions(stream)
 	if err != nil {
 		return
 	}
-
-	s.logger.Info().Msg("upload received: processing the text")
-	txtfn := s.outgoingFolder + "pdftotext" + uuid+ ".txt"
-	_, err = exec.Command("pdftotext", fn, txtfn).Output()
+	// The server extracts the text itself, with pdftotext unless asked otherwise
+	name := opts.Extractor
+	if name == "" {
+		name = extractor.Poppler
+	}
+	e, err := extractor.Lookup(name)
 	if err != nil {
-		err = errors.Wrapf(err,
-			"pdftotext didn't worked")
+		return
+	}
+	// The pure-Go extractor would parse the document inside the server, out of any sandbox
+	if e.Name() == extractor.Go {
+		return messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+			"extractor %s only runs on the workers, use the bidirectional mode", e.Name())
+	}
+	if err = e.Check(opts); err != nil {
 		return
 	}
 
-	//open recently created file
-	txtfile, err := os.Open(txtfn)
+	file, err := messaging.ReceiveFile(messaging.LimitChunks(stream, s.maxFileSize), fn)
 	if err != nil {
-		err = errors.Wrapf(err,
-			"can't open result file")
 		return
 	}
+	file.Close()
+
+	// The extraction stops if the client goes away or at the deadline
+	ctx, cancel := s.processingContext(stream.Context())
+	defer cancel()
+
+	key := s.cacheKey(fn, resolvedOptions(opts, name))
+	cached := s.cache.get(key, txtfn)
+	if cached {
+		s.logger.Info().Msg("upload received: the text is in the cache")
+	} else {
+		s.logger.Info().Msg(fmt.Sprintf("upload received: processing the text with %s", e.Name()))
+		err = s.extractFile(ctx, e, fn, txtfn, opts)
+		if err != nil {
+			return
+		}
+		s.cache.put(key, txtfn)
+	}
+
+	// The text is sent in a single message, which the clients only accept up to 4MB
+	if info, err := os.Stat(txtfn); err == nil && info.Size() > maxTextMessageSize {
+		return messaging.NewError(messaging.ErrorKind_ErrorTooLarge,
+			"text of %d bytes is too large to be sent at once, use the streaming or bidirectional mode",
+			info.Size())
+	}
 
 	// read the result content
-	text, err := ioutil.ReadAll(txtfile)
+	text, err := ioutil.ReadFile(txtfn)
 	if err != nil {
 		err = errors.Wrapf(err,
 			"can't read from result file")
@@ -201,28 +438,27 @@ func (s *ServerGRPC) UploadPdfAndGetText(stream messaging.PdftotextService_Uploa
 
 	// once the transmission finished, send the
 	// confirmation and the text if nothing went wrong
-	err = stream.SendAndClose(&messaging.TextAndStatus{
+	res := &messaging.TextAndStatus{
 		Message: "File received with success",
-		Text:    text,
 		Code:    messaging.StatusCode_Ok,
-	})
-	if err != nil {
-		err = errors.Wrapf(err,
-			"failed to send status code")
-		return
+		Cached:  cached,
 	}
-
-	//Be clean.
-	file.Close()
-	if os.Remove(fn) != nil {
-		err = errors.Wrapf(err,
-			"failed to remove tmp pdf file")
-		return
+	if opts.Output == messaging.OutputMode_OutputPages {
+		res.Pages = messaging.SplitPages(text, opts.FirstPage)
+	} else {
+		res.Text = text
 	}
-	txtfile.Close()
-	if os.Remove(txtfn) != nil {
+	res.TextSha256 = messaging.TextChecksum(res)
+	if opts.IncludeMetadata {
+		res.Metadata, err = s.pdfinfo(ctx, fn)
+		if err != nil {
+			return
+		}
+	}
+	err = stream.SendAndClose(res)
+	if err != nil {
 		err = errors.Wrapf(err,
-			"failed to remove tmp txt file")
+			"failed to send status code")
 		return
 	}
 
@@ -232,36 +468,99 @@ func (s *ServerGRPC) UploadPdfAndGetText(stream messaging.PdftotextService_Uploa
 //UploadPdf implements UploadPdf method of PdftotextService. It receives a pdf file in the form of stream,
 // transforms it into the pdf file and returns an ID of the file.
 func (s *ServerGRPC) UploadPdf(stream messaging.PdftotextService_UploadPdfServer) (err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = withRetryAfter(stream, messaging.StatusError(err)) }()
+
+	if !s.hasHealthyWorker("") {
+		return unavailable("")
+	}
 	uuid := uuid.New().String()
-	fn := s.incomingFolder + "pdftotext" + uuid + ".pdf"
-	file, err := messaging.ReceiveFile(stream, fn)
+	ws := s.newWorkspace(uuid)
+	fn := ws.pdffn
+	// Until a job owns them, the files are the ones of a failed upload
+	owned := false
+	defer func() {
+		if !owned {
+			ws.release()
+		}
+	}()
+	opts, priority, uploadID, err := messaging.ReceiveUploadOptions(stream)
 	if err != nil {
 		return
 	}
-	defer file.Close()
-
-	s.logger.Info().Msg(fmt.Sprintf("%s: upload from client received", uuid))
+	if opts.Output == messaging.OutputMode_OutputPages {
+		return messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+			"per-page output is only available with UploadPdfAndGetText")
+	}
+	if err = extractor.Check(opts); err != nil {
+		return
+	}
+	if !s.hasHealthyWorker(opts.Extractor) {
+		return unavailable(opts.Extractor)
+	}
 
-	//fetch current worker number and update shared worker count
-	s.workermtx.RLock()
-	currentwrk := s.workerCount
-	s.workermtx.RUnlock()
+	// The place is taken before receiving the file, so a full server doesn't store it
+	level := priorityLevels[priority]
+	if !s.queue.reserve(level) {
+		s.logger.Warn().Msg(fmt.Sprintf("upload rejected: the %s queue is full", priority))
+		return s.overloaded("too many jobs are waiting, try again later")
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			s.queue.release(level)
+		}
+	}()
+
+	if uploadID != "" {
+		err = s.resumeUpload(stream, uploadID, fn)
+	} else {
+		var file *os.File
+		file, err = messaging.ReceiveFile(messaging.LimitChunks(stream, s.maxFileSize), fn)
+		if err == nil {
+			file.Close()
+		}
+	}
+	if err != nil {
+		return
+	}
 
-	s.workermtx.Lock()
-	// Increment workerCount even if error occurs
-	s.workerCount++
-	// Come back to the first worker if it was the last
-	s.workerCount %= s.nbWorkers
-	s.workermtx.Unlock()
+	s.logger.Info().Msg(fmt.Sprintf("%s: upload from client received", uuid))
 
-	reschan := make(chan workerRequest)
-	go s.workers[currentwrk].PdfToTextFile(context.Background(), fn, s.outgoingFolder, reschan)
+	// Without extractor, the job runs the default one of the worker it is dispatched to,
+	// its text is looked up in the cache once it is known
+	var key string
+	if opts.Extractor != "" {
+		key = s.cacheKey(fn, opts)
+	}
+	if s.cachedJob(uuid, ws, key, opts) {
+		owned = true
+		// No need to bother a worker, nor to keep the pdf
+		os.Remove(fn)
+		err = stream.SendAndClose(&messaging.IdAndStatus{
+			Uuid:    uuid,
+			Message: "Text is ready to be fetched",
+			Code:    messaging.StatusCode_Ok,
+			Cached:  true,
+		})
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to send status code")
+		}
+		return
+	}
 
+	j, ctx := newJob(uuid, ws, opts, priority, s.jobDeadline(stream.Context()), s.store)
+	owned = true
+	j.cacheKey = key
 	s.reqmtx.Lock()
-	s.requests[uuid] = reschan
+	s.requests[uuid] = j
 	s.reqmtx.Unlock()
 
-	stream.SendAndClose(&messaging.IdAndStatus{
+	s.enqueue(ctx, j, level, true)
+	queued = true
+
+	err = stream.SendAndClose(&messaging.IdAndStatus{
 		Uuid:    uuid,
 		Message: "File is received and will be processed soon",
 		Code:    messaging.StatusCode_Ok,
@@ -273,12 +572,190 @@ func (s *ServerGRPC) UploadPdf(stream messaging.PdftotextService_UploadPdfServer
 		return
 	}
 
-	//Be clean.
-	file.Close()
-	if os.Remove(fn) != nil {
-		err = errors.Wrapf(err,
-			"failed to remove tmp pdf file")
-		s.logger.Error().Err(err)
+	return
+}
+
+// nextWorker returns the healthy worker picked by the scheduler among the ones having room
+// for a request and running the extractorName extractor, empty for any worker.
+// The request is counted in flight until the worker is released.
+func (s *ServerGRPC) nextWorker(extractorName string) (w *workerClientGRPC, err error) {
+	return s.nextWorkerExcept(nil, extractorName)
+}
+
+// nextWorkerExcept is nextWorker among the workers that are not in tried.
+// Tried workers are candidates anyway if they are the only available ones.
+func (s *ServerGRPC) nextWorkerExcept(tried map[*workerClientGRPC]bool, extractorName string) (w *workerClientGRPC, err error) {
+	// Exclusive, so two requests can't take the last place of a worker
+	s.workermtx.Lock()
+	defer s.workermtx.Unlock()
+
+	var (
+		candidates, fallbacks []*workerClientGRPC
+		healthy               int
+	)
+	for _, w := range s.workers {
+		if !w.isHealthy() || !w.supports(extractorName) {
+			continue
+		}
+		healthy++
+		switch {
+		case !w.available():
+		case tried[w]:
+			fallbacks = append(fallbacks, w)
+		default:
+			candidates = append(candidates, w)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = fallbacks
+	}
+	if healthy == 0 {
+		return nil, unavailable(extractorName)
+	}
+	if len(candidates) == 0 {
+		return nil, s.overloaded("all the %d healthy workers are busy", healthy)
+	}
+
+	w = s.scheduler.Next(candidates)
+	w.begin()
+
+	return w, nil
+}
+
+// unavailable returns the error telling no healthy worker runs the extractorName extractor.
+func unavailable(extractorName string) error {
+	if extractorName == "" {
+		return messaging.NewError(messaging.ErrorKind_ErrorWorkerUnavailable,
+			"no healthy worker is available")
+	}
+
+	return messaging.NewError(messaging.ErrorKind_ErrorWorkerUnavailable,
+		"no healthy worker runs the %s extractor", extractorName)
+}
+
+// hasHealthyWorker tells whether one of the workers running the extractorName extractor
+// is healthy, busy or not.
+func (s *ServerGRPC) hasHealthyWorker(extractorName string) bool {
+	return s.healthyWorkers(extractorName) > 0
+}
+
+// healthyWorkers returns the number of healthy workers running the extractorName extractor, busy or not.
+func (s *ServerGRPC) healthyWorkers(extractorName string) (healthy int) {
+	s.workermtx.RLock()
+	defer s.workermtx.RUnlock()
+
+	for _, w := range s.workers {
+		if w.isHealthy() && w.supports(extractorName) {
+			healthy++
+		}
+	}
+
+	return
+}
+
+// process dispatches the job to the worker, or to other ones if it fails,
+// and records its result. The processing is stopped once the ctx is cancelled.
+func (s *ServerGRPC) process(ctx context.Context, j *job, w *workerClientGRPC) {
+	//The pdf is kept for the retries until the job is over
+	defer j.ws.release()
+
+	txtfn := j.ws.txtfn
+	// The queue gives any worker, it may not run the extractor of the job
+	if name := j.opts.Extractor; !w.supports(name) {
+		s.release(w)
+		if w = s.waitWorker(ctx, name); w == nil {
+			s.finishJob(ctx, j, txtfn, ctx.Err())
+			return
+		}
+	}
+	if j.opts.Extractor == "" {
+		// The job runs the default extractor of the worker wherever it is dispatched
+		// again or split, so its text is the one of its cache key
+		opts := resolvedOptions(j.opts, w.defaultExtractor())
+		key := s.cacheKey(j.ws.pdffn, opts)
+		cached := s.cache.get(key, txtfn)
+		j.resolve(opts, key, cached)
+		if cached {
+			s.release(w)
+			s.logger.Info().Msg(fmt.Sprintf("%s: the text is in the cache", j.uuid))
+			s.finishJob(ctx, j, txtfn, nil)
+			return
+		}
+	}
+	if ranges := s.pageRanges(ctx, j); len(ranges) > 1 {
+		s.finishJob(ctx, j, txtfn, s.processParts(ctx, j, w, ranges, txtfn))
+		return
+	}
+
+	err := s.dispatch(ctx, w, j.opts.Extractor, func(w *workerClientGRPC, attempt int) (err error) {
+		msg := "File is dispatched to a worker"
+		if attempt > 1 {
+			msg = fmt.Sprintf("File is dispatched to another worker (attempt %d)", attempt)
+		}
+		if serr := j.setState(messaging.JobState_JobDispatched, msg); serr != nil {
+			s.logger.Error().Err(serr).Msg("failed to update job state")
+		}
+
+		return w.PdfToTextFile(ctx, j, j.ws.pdffn, j.opts, txtfn)
+	})
+	s.finishJob(ctx, j, txtfn, err)
+}
+
+// finishJob records the result of the processing of the job, and keeps its text in the cache.
+// The processing stopped by the ctx deadline is a timeout, whatever the failure it caused.
+func (s *ServerGRPC) finishJob(ctx context.Context, j *job, txtfn string, err error) {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = messaging.NewError(messaging.ErrorKind_ErrorTimeout,
+			"job is not done before its deadline")
+	}
+	if err != nil {
+		err = workerError(err)
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: processing failed", j.uuid))
+		// What the worker wrote stays in the workspace until the failure expires
+		txtfn = ""
+	} else {
+		s.cache.put(j.cacheKey, txtfn)
+	}
+	j.finish(txtfn, err)
+}
+
+// jobDeadline returns the time by which a request made with the ctx context must be done:
+// the deadline of the client, if any, but no later than the maximum processing time.
+func (s *ServerGRPC) jobDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(s.maxProcessingTime)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	return deadline
+}
+
+// processingContext returns the context of a request answered in the call made
+// with the ctx context, ending at the deadline of the request.
+func (s *ServerGRPC) processingContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(ctx, s.jobDeadline(ctx))
+}
+
+// cacheKey returns the key of the text extracted from the fn file with opts,
+// or an empty key if it is not cached.
+func (s *ServerGRPC) cacheKey(fn string, opts *messaging.ExtractionOptions) string {
+	key, err := s.cache.key(fn, opts)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("failed to compute the cache key")
+		return ""
+	}
+
+	return key
+}
+
+// lookupJob returns the job with the given id or a NotFound error.
+func (s *ServerGRPC) lookupJob(id *messaging.Id) (j *job, err error) {
+	s.reqmtx.RLock()
+	j, ok := s.requests[id.Uuid]
+	s.reqmtx.RUnlock()
+
+	if !ok {
+		err = status.Errorf(codes.NotFound, "job %s is not found", id.Uuid)
 		return
 	}
 
@@ -286,40 +763,226 @@ func (s *ServerGRPC) UploadPdf(stream messaging.PdftotextService_UploadPdfServer
 }
 
 // GetText implements GetText method of PdftotextService. It returns a text file in the form of stream,
-// giving the id.
+// giving the id. The result is kept until it expires or the job is deleted, so it can be fetched several times.
 func (s *ServerGRPC) GetText(id *messaging.Id, stream messaging.PdftotextService_GetTextServer) (err error) {
-	s.reqmtx.RLock()
-	//TODO: check if key exists
-	reqchan := s.requests[id.Uuid]
-	s.reqmtx.RUnlock()
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
 
-	//Wait for the client to finish the file processing and return the result filename
-	result := <-reqchan
-	err = result.err
+	j, err := s.lookupJob(id)
 	if err != nil {
-		//TODO: maybe send a error code? to test
-		s.logger.Error().Err(err)
 		return
 	}
 
-	s.logger.Info().Msg(fmt.Sprintf("%s: sending a text..", id.Uuid))
-	err = messaging.SendFile(stream, s.chunkSize, result.txtfn, true)
+	//Wait for the job to finish or for the client to give up
+	select {
+	case <-j.done:
+	case <-stream.Context().Done():
+		return stream.Context().Err()
+	}
+
+	// Hold the job so the result can't expire while being sent
+	j.mtx.RLock()
+	switch j.state {
+	case messaging.JobState_JobFailed:
+		err = j.err
+	case messaging.JobState_JobExpired:
+		err = status.Errorf(codes.FailedPrecondition, "result of job %s is gone: %s", id.Uuid, j.message)
+	case messaging.JobState_JobCancelled:
+		err = status.Errorf(codes.Canceled, "job %s is cancelled", id.Uuid)
+	default:
+		s.logger.Info().Msg(fmt.Sprintf("%s: sending a text..", id.Uuid))
+		err = messaging.SendFile(stream, s.chunkSize, j.txtfn, false)
+		if err != nil {
+			s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: failed to send the text", id.Uuid))
+		} else {
+			s.logger.Info().Msg(fmt.Sprintf("%s: text sent", id.Uuid))
+		}
+	}
+	j.mtx.RUnlock()
+
+	return
+}
+
+// GetStatus implements GetStatus method of PdftotextService. It returns the current state
+// of the job without waiting for it to finish.
+func (s *ServerGRPC) GetStatus(ctx context.Context, id *messaging.Id) (st *messaging.JobStatus, err error) {
+	j, err := s.lookupJob(id)
 	if err != nil {
-		s.logger.Error().Err(err)
 		return
 	}
-	s.logger.Info().Msg(fmt.Sprintf("%s: text sent", id.Uuid))
 
-	// If all is ok, delete this request from the map
-	s.reqmtx.Lock()
-	delete(s.requests, id.Uuid)
-	s.reqmtx.Unlock()
+	return j.status(), nil
+}
+
+// CancelJob implements CancelJob method of PdftotextService. It stops the processing of the job,
+// which kills the pdftotext process on the worker.
+func (s *ServerGRPC) CancelJob(ctx context.Context, id *messaging.Id) (st *messaging.JobStatus, err error) {
+	j, err := s.lookupJob(id)
+	if err != nil {
+		return
+	}
+
+	err = j.stop()
+	if err != nil {
+		err = status.Errorf(codes.FailedPrecondition, "job %s can't be cancelled: %s", id.Uuid, j.status().State)
+		return
+	}
+	s.logger.Info().Msg(fmt.Sprintf("%s: job cancelled", id.Uuid))
+
+	return j.status(), nil
+}
+
+// DeleteJob implements DeleteJob method of PdftotextService. An unfinished job is cancelled,
+// then the files of the job are removed and the server forgets it.
+func (s *ServerGRPC) DeleteJob(ctx context.Context, id *messaging.Id) (st *messaging.JobStatus, err error) {
+	j, err := s.lookupJob(id)
+	if err != nil {
+		return
+	}
+
+	// Both fail if the job is already finished or expired
+	j.stop()
+	j.retire("Job is deleted")
+	if !s.forget(j) {
+		err = status.Errorf(codes.NotFound, "job %s is not found", id.Uuid)
+		return
+	}
+	s.logger.Info().Msg(fmt.Sprintf("%s: job deleted", id.Uuid))
+
+	return j.status(), nil
+}
+
+// ExtractText implements ExtractText method of PdftotextService. The uploaded file is relayed
+// to a worker and the text is streamed back to the client as soon as the worker produces it.
+func (s *ServerGRPC) ExtractText(stream messaging.PdftotextService_ExtractTextServer) (err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = withRetryAfter(stream, messaging.StatusError(err)) }()
+
+	opts, err := messaging.ReceiveOptions(stream)
+	if err != nil {
+		return
+	}
+	if opts.Output == messaging.OutputMode_OutputPages {
+		return messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+			"per-page output is only available with UploadPdfAndGetText")
+	}
+
+	if err = extractor.Check(opts); err != nil {
+		return
+	}
+
+	w, err := s.nextWorker(opts.Extractor)
+	if err != nil {
+		return
+	}
+	defer s.release(w)
+	// The worker is given the deadline of the request
+	ctx, cancel := s.processingContext(stream.Context())
+	defer cancel()
+	s.logger.Info().Msg("relaying an upload to a worker")
+	err = w.ExtractText(ctx, messaging.LimitChunks(stream, s.maxFileSize), opts, stream)
+	if err != nil {
+		err = workerError(err)
+		s.logger.Error().Err(err).Msg("text extraction failed")
+		return
+	}
+
+	return
+}
+
+// GetMetadata implements GetMetadata method of PdftotextService. The uploaded file
+// is sent to a worker which returns the document information.
+func (s *ServerGRPC) GetMetadata(stream messaging.PdftotextService_GetMetadataServer) (err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = withRetryAfter(stream, messaging.StatusError(err)) }()
+
+	uuid := uuid.New().String()
+	ws := s.newWorkspace(uuid)
+	fn := ws.pdffn
+
+	//Be clean, whatever happens.
+	defer ws.release()
+
+	file, err := messaging.ReceiveFile(messaging.LimitChunks(stream, s.maxFileSize), fn)
+	if err != nil {
+		return
+	}
+	file.Close()
+
+	s.logger.Info().Msg(fmt.Sprintf("%s: upload from client received: getting metadata", uuid))
+	w, err := s.nextWorker("")
+	if err != nil {
+		return
+	}
+	ctx, cancel := s.processingContext(stream.Context())
+	defer cancel()
+	var meta *messaging.PdfMetadata
+	err = s.dispatch(ctx, w, "", func(w *workerClientGRPC, attempt int) (err error) {
+		meta, err = w.GetMetadata(ctx, fn)
+		return
+	})
+	if err != nil {
+		err = workerError(err)
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: getting metadata failed", uuid))
+		return
+	}
+
+	err = stream.SendAndClose(meta)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to send metadata")
+		return
+	}
+
+	return
+}
+
+// extractFile writes the text extracted by e from the fn file into txtfn.
+// The processes of e run in the sandbox of the server, as they would on a worker.
+func (s *ServerGRPC) extractFile(ctx context.Context, e extractor.Extractor, fn string, txtfn string, opts *messaging.ExtractionOptions) (err error) {
+	dir, cleanup, err := s.sandbox.JobDir()
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	file, err := os.Create(txtfn)
+	if err != nil {
+		return errors.Wrapf(err,
+			"failed to create result file %s",
+			txtfn)
+	}
+	defer file.Close()
+
+	out := s.sandbox.Output(file)
+	err = e.Extract(ctx, s.sandbox.Runner(dir), fn, out, opts)
+	if err != nil {
+		return s.sandbox.OutputError(out, err)
+	}
 
 	return
+}
+
+// pdfinfo runs pdfinfo on the fn file in the sandbox of the server and parses its output.
+func (s *ServerGRPC) pdfinfo(ctx context.Context, fn string) (meta *messaging.PdfMetadata, err error) {
+	dir, cleanup, err := s.sandbox.JobDir()
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	out, err := s.sandbox.Command(ctx, dir, "pdfinfo", messaging.PdfinfoArgs(fn)...).Output()
+	if err != nil {
+		err = s.sandbox.CommandError(ctx, "pdfinfo", err, nil)
+		return
+	}
 
+	return messaging.ParsePdfInfo(out)
 }
 
 func (s *ServerGRPC) Close() {
+	s.health.Shutdown()
+	s.store.Close()
 	if s.server != nil {
 		s.server.Stop()
 	}
@@ -3,31 +3,57 @@ package server
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"path"
-	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
 	"gitlab.com/gaydamakha/ter-grpc/messaging"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 
 	_ "google.golang.org/grpc/encoding/gzip"
 )
 
 type workerClientGRPC struct {
+	// requests in flight, accessed atomically so kept first for alignment
+	inflight  int64
 	logger    zerolog.Logger
 	conn      *grpc.ClientConn
 	client    messaging.PdftotextWorkerClient
+	health    healthpb.HealthClient
+	address   string
 	chunkSize int
+	// share of the requests given by weighted schedulers
+	weight int
+	// requests given at most at once
+	maxInFlight int64
+	// set for the workers that registered themselves
+	id string
+	// state of the worker as seen by the prober and the registry
+	healthy       bool
+	failures      int
+	lastError     string
+	lastCheck     time.Time
+	labels        map[string]string
+	lastHeartbeat time.Time
+	// extractors the worker can run, its default one first, nil until it tells them
+	extractors []string
+	statemtx   *sync.RWMutex
 }
 
 type workerClientGRPCConfig struct {
 	Address         string
+	Weight          int
+	MaxInFlight     int
 	ChunkSize       int
 	RootCertificate string
 	Compress        bool
@@ -89,77 +115,299 @@ func newWorkerClientGRPC(cfg workerClientGRPCConfig) (c workerClientGRPC, err er
 	}
 
 	c.client = messaging.NewPdftotextWorkerClient(c.conn)
+	c.health = healthpb.NewHealthClient(c.conn)
+	c.address = cfg.Address
+	c.weight = cfg.Weight
+	if c.weight == 0 {
+		c.weight = 1
+	}
+	c.maxInFlight = int64(cfg.MaxInFlight)
+	if c.maxInFlight == 0 {
+		c.maxInFlight = 1
+	}
+	// Workers are trusted until the prober says otherwise
+	c.healthy = true
+	c.statemtx = &sync.RWMutex{}
 
 	return
 }
 
-func (c *workerClientGRPC) PdfToTextFile(ctx context.Context, f string, dir string, reschan chan workerRequest) {
-	var (
-		status *messaging.TextAndStatus
-		result workerRequest
-	)
-
-	result = workerRequest{}
-
+//PdfToTextFile sends the file to the worker and writes the text it streams back into txtfn,
+//so neither the text has to fit in a message nor the server buffers it whole.
+//The j job, if any, is moved to the processing state once the worker has received the whole file.
+func (c *workerClientGRPC) PdfToTextFile(
+	ctx context.Context,
+	j *job,
+	f string,
+	opts *messaging.ExtractionOptions,
+	txtfn string) (err error) {
 	// Open a stream-based connection with the
 	// gRPC server
-	c.logger.Info().Msg("creating upload stream to worker...")
+	c.logger.Info().Msg("creating extraction stream to worker...")
 
-	stream, err := c.client.UploadPdfAndGetText(ctx)
+	stream, err := c.client.ExtractText(ctx)
 	if err != nil {
-		result.err = errors.Wrapf(err,
-			"failed to create upload stream for file %s",
+		err = errors.Wrapf(err,
+			"failed to create extraction stream for file %s",
 			f)
-		reschan <- result
 		return
 	}
-	defer stream.CloseSend()
-
-	c.logger.Info().Msg("sending a file to worker...")
 
-	err = messaging.SendFile(stream, c.chunkSize, f, false)
+	// The pages are split from the text file when it is given, if asked
+	opts = proto.Clone(opts).(*messaging.ExtractionOptions)
+	opts.Output = messaging.OutputMode_OutputPlain
+	err = messaging.SendOptions(stream, opts)
+	if err == nil {
+		c.logger.Info().Msg("sending a file to worker...")
+		err = messaging.SendFile(stream, c.chunkSize, f, false)
+	}
 	if err != nil {
-		result.err = err
-		reschan <- result
+		// The worker aborted the stream, its status tells why
+		if _, rerr := stream.Recv(); rerr != nil && rerr != io.EOF {
+			err = rerr
+		}
+		return
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to close upload to worker")
 		return
 	}
 
-	c.logger.Info().Msg("file sent to worker: receiving the result")
+	c.logger.Info().Msg("file sent to worker: receiving the text")
+	if j != nil {
+		err = j.setState(messaging.JobState_JobProcessing, "File is being processed by a worker")
+		if err != nil {
+			c.logger.Error().Err(err).Msg("failed to update job state")
+		}
+	}
 
-	status, err = stream.CloseAndRecv()
+	file, err := os.Create(txtfn)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create result file %s",
+			txtfn)
+		return
+	}
+	defer file.Close()
+
+	err = messaging.ReceiveText(stream, file)
 	if err != nil {
-		result.err = errors.Wrapf(err,
-			"failed to receive upstream status response")
-		reschan <- result
 		return
 	}
 
 	c.logger.Info().Msg("received!")
 
-	if status.Code != messaging.StatusCode_Ok {
-		result.err = errors.Errorf(
-			"upload failed - msg: %s",
-			status.Message)
-		reschan <- result
+	return
+}
+
+//ExtractText relays the file chunks read from in to the worker,
+//then relays the text chunks produced by the worker to out.
+func (c *workerClientGRPC) ExtractText(
+	ctx context.Context,
+	in messaging.ChunkReceiver,
+	opts *messaging.ExtractionOptions,
+	out messaging.TextChunkSender) (err error) {
+	stream, err := c.client.ExtractText(ctx)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create extraction stream")
+		return
+	}
+
+	err = messaging.SendOptions(stream, opts)
+	if err != nil {
 		return
 	}
 
-	fn := filepath.Base(f)
-	txtfn := dir + strings.TrimSuffix(fn, path.Ext(fn)) + ".txt"
-	err = ioutil.WriteFile(txtfn, status.Text, 0644)
+	for {
+		chunk, err := in.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed while reading chunks from stream")
+		}
+		err = stream.Send(chunk)
+		if err == io.EOF {
+			// The worker aborted the stream, its status tells why
+			if _, rerr := stream.Recv(); rerr != nil && rerr != io.EOF {
+				return rerr
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed to relay chunk to worker")
+		}
+	}
+
+	err = stream.CloseSend()
 	if err != nil {
-		result.err = errors.Wrapf(err,
-			"failed to create result file %s",
-			txtfn)
-		reschan <- result
+		err = errors.Wrapf(err,
+			"failed to close upload to worker")
 		return
 	}
 
-	result.txtfn = txtfn
-	reschan <- result
+	c.logger.Info().Msg("file relayed to worker: relaying the text")
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed while reading text chunks from worker")
+		}
+		err = out.Send(chunk)
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed to relay text chunk")
+		}
+	}
+
 	return
 }
 
+//GetMetadata sends the file to the worker and returns the document information.
+func (c *workerClientGRPC) GetMetadata(ctx context.Context, f string) (meta *messaging.PdfMetadata, err error) {
+	stream, err := c.client.GetMetadata(ctx)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create upload stream for file %s",
+			f)
+		return
+	}
+	defer stream.CloseSend()
+
+	err = messaging.SendFile(stream, c.chunkSize, f, false)
+	if err != nil {
+		// The worker aborted the stream, its status tells why
+		if _, rerr := stream.CloseAndRecv(); rerr != nil {
+			err = rerr
+		}
+		return
+	}
+
+	meta, err = stream.CloseAndRecv()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to receive metadata")
+		return
+	}
+
+	return
+}
+
+//Check asks the worker whether it is able to process files.
+func (c *workerClientGRPC) Check(ctx context.Context) (err error) {
+	res, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{
+		Service: "messaging.PdftotextWorker",
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to check worker health")
+		return
+	}
+
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		err = errors.Errorf("worker is %s", res.Status)
+		return
+	}
+
+	return
+}
+
+//Capabilities asks the worker which extractors it can run, its default one first.
+//Workers that predate the extractors only run pdftotext.
+func (c *workerClientGRPC) Capabilities(ctx context.Context) (extractors []string, err error) {
+	res, err := c.client.GetCapabilities(ctx, &messaging.CapabilitiesRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return []string{extractor.Poppler}, nil
+	}
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to get worker capabilities")
+		return
+	}
+
+	return res.Extractors, nil
+}
+
+// setExtractors records the extractors the worker told it can run, if any.
+func (c *workerClientGRPC) setExtractors(extractors []string) {
+	if len(extractors) == 0 {
+		return
+	}
+
+	c.statemtx.Lock()
+	defer c.statemtx.Unlock()
+
+	c.extractors = extractors
+}
+
+// defaultExtractor returns the name of the extractor the worker runs when none is asked.
+func (c *workerClientGRPC) defaultExtractor() string {
+	c.statemtx.RLock()
+	defer c.statemtx.RUnlock()
+
+	if len(c.extractors) == 0 {
+		return extractor.Poppler
+	}
+
+	return c.extractors[0]
+}
+
+// supports tells whether the worker can run the name extractor, any worker running
+// its default one. Workers that didn't tell their extractors yet are assumed to run pdftotext.
+func (c *workerClientGRPC) supports(name string) bool {
+	if name == "" {
+		return true
+	}
+
+	c.statemtx.RLock()
+	defer c.statemtx.RUnlock()
+
+	if c.extractors == nil {
+		return name == extractor.Poppler
+	}
+	for _, e := range c.extractors {
+		if e == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// workerError turns the failure of a call to a worker into an error for the client.
+// Errors of the worker are kept as is, transport failures mean the worker is unavailable.
+func workerError(err error) error {
+	if err == nil || messaging.ErrorDetailOf(err) != nil {
+		return messaging.StatusError(err)
+	}
+
+	st, ok := status.FromError(errors.Cause(err))
+	if !ok {
+		return messaging.NewError(messaging.ErrorKind_ErrorInternal, "%s", err)
+	}
+	switch st.Code() {
+	case codes.Unavailable:
+		return messaging.NewError(messaging.ErrorKind_ErrorWorkerUnavailable,
+			"worker is unavailable: %s", st.Message())
+	case codes.DeadlineExceeded:
+		return messaging.NewError(messaging.ErrorKind_ErrorTimeout,
+			"worker took too long: %s", st.Message())
+	case codes.Canceled:
+		return st.Err()
+	}
+
+	return messaging.NewError(messaging.ErrorKind_ErrorInternal,
+		"worker failed: %s", st.Message())
+}
+
 func (c *workerClientGRPC) Close() {
 	if c.conn != nil {
 		c.conn.Close()
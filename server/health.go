@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Name of the service reported by the health server
+const serviceName = "messaging.PdftotextService"
+
+// watchWorkers periodically checks the health of every worker.
+// The server is serving as long as one of its workers is healthy.
+// The number of healthy workers is only logged when it changes.
+func (s *ServerGRPC) watchWorkers() {
+	lastHealthy, lastTotal := -1, -1
+	for {
+		healthy, total := s.probeWorkers()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if healthy == 0 {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		s.health.SetServingStatus("", status)
+		s.health.SetServingStatus(serviceName, status)
+		if healthy != lastHealthy || total != lastTotal {
+			s.logger.Info().Msg(fmt.Sprintf("%d/%d workers are healthy", healthy, total))
+			lastHealthy, lastTotal = healthy, total
+		}
+
+		time.Sleep(s.healthInterval)
+	}
+}
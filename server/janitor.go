@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// retentionPolicy tells how long the jobs are kept once finished
+type retentionPolicy struct {
+	// text of a done job
+	result time.Duration
+	// failure of a failed or cancelled job
+	failure time.Duration
+	// state of an expired job, answered by GetStatus
+	expired time.Duration
+}
+
+// ttl returns how long a job is kept in the given terminal state.
+func (p retentionPolicy) ttl(state messaging.JobState) time.Duration {
+	switch state {
+	case messaging.JobState_JobDone:
+		return p.result
+	case messaging.JobState_JobExpired:
+		return p.expired
+	}
+
+	return p.failure
+}
+
+// interval returns the delay between two rounds of the janitor,
+// so the jobs don't outlive their TTL by much.
+func (p retentionPolicy) interval() time.Duration {
+	d := p.result
+	for _, ttl := range []time.Duration{p.failure, p.expired} {
+		if ttl < d {
+			d = ttl
+		}
+	}
+	d /= 4
+	if d < time.Second {
+		d = time.Second
+	}
+	if d > time.Minute {
+		d = time.Minute
+	}
+
+	return d
+}
+
+// janitor expires the results kept longer than their TTL and forgets the jobs
+// expired for long enough, so neither the jobs nor their files pile up.
+// It compacts the journal of the jobs as well.
+func (s *ServerGRPC) janitor() {
+	ticker := time.NewTicker(s.retention.interval())
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.collectJobs(now)
+	}
+}
+
+// collectJobs makes a round of the janitor.
+func (s *ServerGRPC) collectJobs(now time.Time) {
+	expired, forgotten := 0, 0
+	for _, j := range s.jobs() {
+		state, since := j.age()
+		if since.IsZero() || now.Sub(since) < s.retention.ttl(state) {
+			continue
+		}
+
+		if state == messaging.JobState_JobExpired {
+			if s.forget(j) {
+				forgotten++
+			}
+		} else if j.expire() {
+			atomic.AddInt64(&s.expiredJobs, 1)
+			expired++
+		}
+	}
+
+	if expired > 0 || forgotten > 0 {
+		s.logger.Info().Msg(fmt.Sprintf("janitor: %d results expired, %d jobs forgotten",
+			expired, forgotten))
+	}
+
+	if err := s.store.compact(); err != nil {
+		s.logger.Error().Err(err).Msg("janitor: failed to compact the journal")
+	}
+}
+
+// jobs returns the jobs kept by the server.
+func (s *ServerGRPC) jobs() (jobs []*job) {
+	s.reqmtx.RLock()
+	defer s.reqmtx.RUnlock()
+
+	jobs = make([]*job, 0, len(s.requests))
+	for _, j := range s.requests {
+		jobs = append(jobs, j)
+	}
+
+	return
+}
+
+// forget stops keeping the job. It returns false if it was already forgotten.
+func (s *ServerGRPC) forget(j *job) bool {
+	s.reqmtx.Lock()
+	defer s.reqmtx.Unlock()
+
+	if s.requests[j.uuid] != j {
+		return false
+	}
+	delete(s.requests, j.uuid)
+	if s.cachedJobs[j.cacheKey] == j {
+		delete(s.cachedJobs, j.cacheKey)
+	}
+	atomic.AddInt64(&s.forgottenJobs, 1)
+
+	return true
+}
+
+// age returns the state of the job and when it was reached,
+// or a zero time if the job is not finished yet.
+func (j *job) age() (state messaging.JobState, since time.Time) {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+
+	switch j.state {
+	case messaging.JobState_JobDone,
+		messaging.JobState_JobFailed,
+		messaging.JobState_JobCancelled:
+		return j.state, j.finished
+	case messaging.JobState_JobExpired:
+		return j.state, j.retired
+	}
+
+	return j.state, time.Time{}
+}
+
+// size returns the disk space taken by the files of the workspace.
+func (ws *workspace) size() (size int64) {
+	for _, fn := range []string{ws.pdffn, ws.txtfn} {
+		if info, err := os.Stat(fn); err == nil {
+			size += info.Size()
+		}
+	}
+
+	return
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// Allowed transitions of the job state machine
+var jobTransitions = map[messaging.JobState][]messaging.JobState{
+	messaging.JobState_JobQueued: {
+		messaging.JobState_JobDispatched,
+		// answered from the cache
+		messaging.JobState_JobDone,
+		messaging.JobState_JobFailed,
+		messaging.JobState_JobCancelled,
+	},
+	messaging.JobState_JobDispatched: {
+		// dispatched again after a failure of the worker
+		messaging.JobState_JobDispatched,
+		messaging.JobState_JobProcessing,
+		messaging.JobState_JobFailed,
+		messaging.JobState_JobCancelled,
+	},
+	messaging.JobState_JobProcessing: {
+		messaging.JobState_JobDispatched,
+		messaging.JobState_JobDone,
+		messaging.JobState_JobFailed,
+		messaging.JobState_JobCancelled,
+	},
+	messaging.JobState_JobDone: {
+		messaging.JobState_JobExpired,
+	},
+	messaging.JobState_JobFailed: {
+		messaging.JobState_JobExpired,
+	},
+	messaging.JobState_JobCancelled: {
+		messaging.JobState_JobExpired,
+	},
+}
+
+// job keeps the state of a request made via UploadPdf
+type job struct {
+	uuid    string
+	state   messaging.JobState
+	message string
+	// files of the job, held until its result expires or the job is deleted
+	ws    *workspace
+	opts  *messaging.ExtractionOptions
+	txtfn string
+	// priority given by the client, ordering the queue
+	priority messaging.Priority
+	// key of the text in the cache, and whether the text comes from it
+	cacheKey string
+	cached   bool
+	err      error
+	// journal recording every change of the job
+	store *jobStore
+	// stops the processing of the job
+	cancel context.CancelFunc
+	// closed once the job reaches the done, failed or cancelled state
+	done chan struct{}
+	// when the job reached this state, and its expired state
+	finished time.Time
+	retired  time.Time
+	// time by which the job must be done, zero for none
+	deadline time.Time
+	mtx      *sync.RWMutex
+}
+
+// newJob creates a queued job processing the pdf of the workspace, and records it in the store.
+// The job takes over the hold of the caller on the workspace.
+// The returned context is cancelled when the job is, or at its deadline.
+func newJob(
+	uuid string,
+	ws *workspace,
+	opts *messaging.ExtractionOptions,
+	priority messaging.Priority,
+	deadline time.Time,
+	store *jobStore) (j *job, ctx context.Context) {
+	var cancel context.CancelFunc
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(context.Background())
+	} else {
+		ctx, cancel = context.WithDeadline(context.Background(), deadline)
+	}
+	j = &job{
+		uuid:     uuid,
+		state:    messaging.JobState_JobQueued,
+		message:  "File is received and will be processed soon",
+		ws:       ws,
+		opts:     opts,
+		priority: priority,
+		deadline: deadline,
+		store:    store,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		mtx:      &sync.RWMutex{},
+	}
+	j.persist()
+
+	return
+}
+
+// resolve gives the job the options naming the extractor it runs and the cache key
+// of its text, which is already in the cache if cached is set.
+func (j *job) resolve(opts *messaging.ExtractionOptions, cacheKey string, cached bool) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.opts = opts
+	j.cacheKey = cacheKey
+	j.cached = cached
+	j.persist()
+}
+
+// setState moves the job to the given state if the transition is allowed.
+func (j *job) setState(state messaging.JobState, message string) (err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	return j.transition(state, message)
+}
+
+// transition must be called with the job lock held.
+func (j *job) transition(state messaging.JobState, message string) (err error) {
+	for _, next := range jobTransitions[j.state] {
+		if next == state {
+			j.state = state
+			j.message = message
+			j.persist()
+			return
+		}
+	}
+
+	return errors.Errorf("%s: transition from %s to %s is not allowed",
+		j.uuid, j.state, state)
+}
+
+// finish moves the job to a terminal state depending on the result,
+// which is kept until expired by the janitor or deleted.
+func (j *job) finish(txtfn string, err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	select {
+	case <-j.done:
+		// Cancelled or timed out before: the result came too late, the workspace removes it
+		return
+	default:
+	}
+
+	if err != nil {
+		j.err = err
+		j.transition(messaging.JobState_JobFailed, err.Error())
+	} else {
+		j.txtfn = txtfn
+		j.transition(messaging.JobState_JobDone, "Text is ready to be fetched")
+	}
+	j.cancel()
+	close(j.done)
+	j.finished = time.Now()
+}
+
+// stop cancels the processing of the job if it is not finished yet.
+func (j *job) stop() (err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	err = j.transition(messaging.JobState_JobCancelled, "Job is cancelled")
+	if err != nil {
+		return
+	}
+	j.cancel()
+	close(j.done)
+	j.finished = time.Now()
+
+	return
+}
+
+// timeout fails the job if it is still waiting for a worker at its deadline.
+// Dispatched jobs fail by themselves, as their processing is stopped.
+func (j *job) timeout() {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.state != messaging.JobState_JobQueued {
+		return
+	}
+	j.err = messaging.NewError(messaging.ErrorKind_ErrorTimeout,
+		"job is not dispatched before its deadline")
+	j.transition(messaging.JobState_JobFailed, j.err.Error())
+	j.cancel()
+	close(j.done)
+	j.finished = time.Now()
+}
+
+// restore puts the job back in the terminal state of the record.
+func (j *job) restore(r *jobRecord) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	j.state = r.State
+	j.message = r.Message
+	j.txtfn = r.Txt
+	j.cached = r.Cached
+	if r.State == messaging.JobState_JobFailed {
+		j.err = r.failure()
+	}
+	j.cancel()
+	close(j.done)
+	j.finished = r.Time
+}
+
+// expire moves the job to the expired state and releases its files.
+// It returns false if the job is not finished or already expired.
+func (j *job) expire() bool {
+	return j.retire("Result has expired")
+}
+
+func (j *job) retire(message string) bool {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.transition(messaging.JobState_JobExpired, message) != nil {
+		return false
+	}
+	j.txtfn = ""
+	j.retired = time.Now()
+	j.ws.release()
+
+	return true
+}
+
+// persist records the job in its store. It must be called with the job lock held.
+func (j *job) persist() {
+	err := j.store.save(j.record())
+	if err != nil {
+		j.store.logger.Error().Err(err).Msg("failed to record job")
+	}
+}
+
+func (j *job) status() *messaging.JobStatus {
+	j.mtx.RLock()
+	defer j.mtx.RUnlock()
+
+	return &messaging.JobStatus{
+		Uuid:    j.uuid,
+		State:   j.state,
+		Message: j.message,
+		Cached:  j.cached,
+	}
+}
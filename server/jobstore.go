@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/status"
+)
+
+// jobRecord is a line of the journal, written at each change of a job
+type jobRecord struct {
+	Uuid    string             `json:"uuid"`
+	State   messaging.JobState `json:"state"`
+	Message string             `json:"message,omitempty"`
+	// uploaded pdf, kept until the job is over
+	Pdf string `json:"pdf,omitempty"`
+	// result of a done job
+	Txt string `json:"txt,omitempty"`
+	// encoded extraction options
+	Options  []byte             `json:"options,omitempty"`
+	Priority messaging.Priority `json:"priority,omitempty"`
+	// key of the text in the cache
+	Cache  string `json:"cache,omitempty"`
+	Cached bool   `json:"cached,omitempty"`
+	// encoded status of a failed job
+	Error    []byte    `json:"error,omitempty"`
+	Deadline time.Time `json:"deadline,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Size of the longest line of the journal, the longer ones are skipped
+const maxRecordSize = 1 << 20
+
+// Number of records appended to the journal since it was compacted, beyond which
+// the janitor compacts it again once they outnumber the records kept
+const compactionThreshold = 1000
+
+// jobStore is an append-only journal of the jobs, replayed at startup.
+// A nil jobStore doesn't record anything.
+type jobStore struct {
+	logger zerolog.Logger
+	fn     string
+	file   *os.File
+	mtx    *sync.Mutex
+	// records kept by the last compaction and appended since
+	kept, appended int
+}
+
+// openJobStore replays the fn journal and returns the last record of each job
+// not expired yet. The journal is then compacted, keeping only these records.
+func openJobStore(fn string, logger zerolog.Logger) (st *jobStore, records []*jobRecord, err error) {
+	st = &jobStore{
+		logger: logger,
+		fn:     fn,
+		mtx:    &sync.Mutex{},
+	}
+	records, err = st.rewrite()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return st, records, nil
+}
+
+// compact rewrites the journal if it has grown enough since the last time,
+// so it doesn't keep the records of the jobs that are long gone.
+func (st *jobStore) compact() (err error) {
+	if st == nil {
+		return
+	}
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	if st.appended < compactionThreshold || st.appended < st.kept {
+		return
+	}
+	appended := st.appended
+	records, err := st.rewrite()
+	if err != nil {
+		return
+	}
+	st.logger.Info().Msg(fmt.Sprintf("journal compacted: %d records kept out of %d appended since the last time",
+		len(records), appended))
+
+	return
+}
+
+// rewrite replaces the journal with the last record of each job not expired yet, and
+// returns them. Records are appended to the new journal then. It must be called with
+// the store lock held, unless the store is not shared yet.
+func (st *jobStore) rewrite() (records []*jobRecord, err error) {
+	records, err = readJournal(st.fn)
+	if err != nil {
+		return
+	}
+	kept := records[:0]
+	var buf bytes.Buffer
+	for _, r := range records {
+		if r.State == messaging.JobState_JobExpired {
+			// Nothing is left of expired jobs
+			continue
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			continue
+		}
+		kept = append(kept, r)
+		buf.Write(append(line, '\n'))
+	}
+
+	tmpfn := st.fn + ".tmp"
+	file, err := os.OpenFile(tmpfn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create journal %s",
+			tmpfn)
+		return
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpfn, st.fn)
+	}
+	if err != nil {
+		file.Close()
+		err = errors.Wrapf(err,
+			"failed to replace journal %s",
+			st.fn)
+		return
+	}
+
+	if st.file != nil {
+		st.file.Close()
+	}
+	st.file = file
+	st.kept, st.appended = len(kept), 0
+
+	return kept, nil
+}
+
+// readJournal returns the last record of each job found in the fn journal, in order.
+// Unreadable lines, such as a truncated last line left by a crash, are ignored,
+// as are the lines longer than maxRecordSize.
+func readJournal(fn string) (records []*jobRecord, err error) {
+	file, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to open journal %s",
+			fn)
+		return
+	}
+	defer file.Close()
+
+	last := make(map[string]int)
+	reader := bufio.NewReaderSize(file, maxRecordSize)
+	for {
+		line, rerr := reader.ReadSlice('\n')
+		if rerr == bufio.ErrBufferFull {
+			// Skip the rest of the line
+			for rerr == bufio.ErrBufferFull {
+				_, rerr = reader.ReadSlice('\n')
+			}
+			line = nil
+		}
+		if rerr != nil && rerr != io.EOF {
+			err = errors.Wrapf(rerr,
+				"failed to read journal %s",
+				fn)
+			return
+		}
+
+		r := &jobRecord{}
+		if len(line) > 0 && json.Unmarshal(line, r) == nil {
+			if i, ok := last[r.Uuid]; ok {
+				records[i] = r
+			} else {
+				last[r.Uuid] = len(records)
+				records = append(records, r)
+			}
+		}
+		if rerr == io.EOF {
+			return
+		}
+	}
+}
+
+// save appends the record to the journal and flushes it to the disk.
+func (st *jobStore) save(r *jobRecord) (err error) {
+	if st == nil {
+		return
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to encode job %s",
+			r.Uuid)
+		return
+	}
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.appended++
+	_, err = st.file.Write(append(line, '\n'))
+	if err == nil {
+		err = st.file.Sync()
+	}
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to write job %s into journal %s",
+			r.Uuid, st.fn)
+		return
+	}
+
+	return
+}
+
+func (st *jobStore) Close() {
+	if st != nil {
+		st.file.Close()
+	}
+}
+
+// record describes the job for the journal. It must be called with the job lock held.
+func (j *job) record() *jobRecord {
+	r := &jobRecord{
+		Uuid:     j.uuid,
+		State:    j.state,
+		Message:  j.message,
+		Pdf:      j.ws.pdffn,
+		Txt:      j.txtfn,
+		Priority: j.priority,
+		Cache:    j.cacheKey,
+		Cached:   j.cached,
+		Deadline: j.deadline,
+		Time:     time.Now(),
+	}
+	if j.opts != nil {
+		r.Options, _ = proto.Marshal(j.opts)
+	}
+	if j.err != nil {
+		r.Error, _ = proto.Marshal(status.Convert(j.err).Proto())
+	}
+
+	return r
+}
+
+// options and failure decode the extraction options and the error of a record.
+func (r *jobRecord) options() (opts *messaging.ExtractionOptions) {
+	opts = &messaging.ExtractionOptions{}
+	if proto.Unmarshal(r.Options, opts) != nil {
+		return &messaging.ExtractionOptions{}
+	}
+
+	return
+}
+
+func (r *jobRecord) failure() error {
+	st := &spb.Status{}
+	if len(r.Error) == 0 || proto.Unmarshal(r.Error, st) != nil {
+		return messaging.NewError(messaging.ErrorKind_ErrorInternal, "%s", r.Message)
+	}
+
+	return status.FromProto(st).Err()
+}
+
+// restoreJobs recreates the jobs of the journal. Unfinished jobs are queued again,
+// the results of the finished ones can be fetched until they expire.
+func (s *ServerGRPC) restoreJobs(records []*jobRecord) {
+	for _, r := range records {
+		deadline := r.Deadline
+		if deadline.IsZero() {
+			// Recorded before deadlines existed
+			deadline = s.jobDeadline(context.Background())
+		}
+		j, ctx := newJob(r.Uuid, s.newWorkspace(r.Uuid), r.options(), r.Priority, deadline, nil)
+		j.store = s.store
+		j.cacheKey = r.Cache
+
+		switch r.State {
+		case messaging.JobState_JobQueued,
+			messaging.JobState_JobDispatched,
+			messaging.JobState_JobProcessing:
+			if _, err := os.Stat(j.ws.pdffn); err != nil {
+				j.finish("", messaging.NewError(messaging.ErrorKind_ErrorInternal,
+					"uploaded file is lost: %s", err))
+				break
+			}
+			j.message = "Job is queued again after a restart of the server"
+			j.persist()
+			s.enqueue(ctx, j, priorityLevels[r.Priority], false)
+		case messaging.JobState_JobDone,
+			messaging.JobState_JobFailed,
+			messaging.JobState_JobCancelled:
+			j.restore(r)
+			if time.Since(r.Time) >= s.retention.ttl(r.State) {
+				// Recorded as expired, so the journal forgets it
+				j.expire()
+				continue
+			}
+		default:
+			// Expired jobs are forgotten
+			continue
+		}
+
+		s.requests[r.Uuid] = j
+	}
+
+	s.logger.Info().Msg(fmt.Sprintf("%d jobs restored from the journal, %d to process again",
+		len(s.requests), s.queue.len()))
+}
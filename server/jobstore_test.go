@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// writeJournal writes the records into a journal of the dir directory, followed by the
+// extra raw lines, and returns its name.
+func writeJournal(t *testing.T, dir string, records []*jobRecord, extra ...string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	for _, line := range extra {
+		buf.WriteString(line)
+	}
+
+	fn := filepath.Join(dir, "jobs.journal")
+	if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fn
+}
+
+// journalLines returns the number of lines of the fn journal.
+func journalLines(t *testing.T, fn string) int {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.Count(data, []byte("\n"))
+}
+
+// uuids returns the ids of the records, in order.
+func uuids(records []*jobRecord) (ids []string) {
+	for _, r := range records {
+		ids = append(ids, r.Uuid)
+	}
+
+	return
+}
+
+func TestReadJournal(t *testing.T) {
+	queued := &jobRecord{Uuid: "a", State: messaging.JobState_JobQueued}
+	done := &jobRecord{Uuid: "a", State: messaging.JobState_JobDone, Txt: "a.txt"}
+	other := &jobRecord{Uuid: "b", State: messaging.JobState_JobQueued}
+
+	tests := []struct {
+		name    string
+		records []*jobRecord
+		extra   []string
+		ids     []string
+		states  []messaging.JobState
+	}{
+		{"last record of each job", []*jobRecord{queued, other, done}, nil,
+			[]string{"a", "b"}, []messaging.JobState{messaging.JobState_JobDone, messaging.JobState_JobQueued}},
+		{"truncated last line", []*jobRecord{queued}, []string{`{"uuid":"a","state":4,"tx`},
+			[]string{"a"}, []messaging.JobState{messaging.JobState_JobQueued}},
+		{"unreadable line", []*jobRecord{queued}, []string{"garbage\n", `{"uuid":"b","state":1}` + "\n"},
+			[]string{"a", "b"}, []messaging.JobState{messaging.JobState_JobQueued, messaging.JobState_JobQueued}},
+		{"oversized line", []*jobRecord{queued},
+			[]string{`{"uuid":"a","state":4,"message":"` + strings.Repeat("x", maxRecordSize) + `"}` + "\n", `{"uuid":"b","state":1}` + "\n"},
+			[]string{"a", "b"}, []messaging.JobState{messaging.JobState_JobQueued, messaging.JobState_JobQueued}},
+		{"empty journal", nil, nil, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := writeJournal(t, t.TempDir(), test.records, test.extra...)
+
+			records, err := readJournal(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := uuids(records); strings.Join(ids, ",") != strings.Join(test.ids, ",") {
+				t.Fatalf("jobs are %v, expected %v", ids, test.ids)
+			}
+			for i, r := range records {
+				if r.State != test.states[i] {
+					t.Errorf("job %s is %s, expected %s", r.Uuid, r.State, test.states[i])
+				}
+			}
+		})
+	}
+
+	records, err := readJournal(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || records != nil {
+		t.Errorf("missing journal gives %v, %v", records, err)
+	}
+}
+
+func TestJobStoreRestart(t *testing.T) {
+	dir := t.TempDir()
+	fn := writeJournal(t, dir, []*jobRecord{
+		{Uuid: "a", State: messaging.JobState_JobQueued},
+		{Uuid: "b", State: messaging.JobState_JobDone},
+		{Uuid: "a", State: messaging.JobState_JobProcessing},
+		{Uuid: "c", State: messaging.JobState_JobDone},
+		{Uuid: "c", State: messaging.JobState_JobExpired},
+	}, `{"uuid":"d","sta`)
+
+	st, records, err := openJobStore(fn, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := strings.Join(uuids(records), ","); ids != "a,b" {
+		t.Errorf("jobs are %s, expected a,b", ids)
+	}
+	if n := journalLines(t, fn); n != 2 {
+		t.Errorf("journal has %d lines once opened, expected 2", n)
+	}
+
+	// The records of the new run follow the compacted ones
+	if err = st.save(&jobRecord{Uuid: "a", State: messaging.JobState_JobDone}); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.save(&jobRecord{Uuid: "b", State: messaging.JobState_JobExpired}); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	st, records, err = openJobStore(fn, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if len(records) != 1 || records[0].Uuid != "a" || records[0].State != messaging.JobState_JobDone {
+		t.Errorf("jobs restored are %v, expected a done", uuids(records))
+	}
+}
+
+func TestJobStoreCompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		appended int
+		lines    int
+	}{
+		{"below the threshold", compactionThreshold - 1, compactionThreshold - 1},
+		{"beyond the threshold", compactionThreshold, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := writeJournal(t, t.TempDir(), nil)
+			st, _, err := openJobStore(fn, zerolog.Nop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+
+			// Jobs a and b are kept, c has expired
+			for _, id := range []string{"a", "b"} {
+				if err = st.save(&jobRecord{Uuid: id, State: messaging.JobState_JobDone}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for st.appended < test.appended {
+				if err = st.save(&jobRecord{Uuid: "c", State: messaging.JobState_JobExpired}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err = st.compact(); err != nil {
+				t.Fatal(err)
+			}
+			if n := journalLines(t, fn); n != test.lines {
+				t.Errorf("journal has %d lines, expected %d", n, test.lines)
+			}
+			if err = st.save(&jobRecord{Uuid: "a", State: messaging.JobState_JobExpired}); err != nil {
+				t.Fatal(err)
+			}
+			if n := journalLines(t, fn); n != test.lines+1 {
+				t.Errorf("journal has %d lines after a save, expected %d", n, test.lines+1)
+			}
+		})
+	}
+}
+
+func TestRestoreJobs(t *testing.T) {
+	dir := t.TempDir() + string(filepath.Separator)
+	if err := ioutil.WriteFile(dir+workspacePrefix+"queued.pdf", []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+workspacePrefix+"processing.pdf", []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The error of a failed job is recorded as it is by the server
+	failed := &job{
+		uuid:  "failed",
+		state: messaging.JobState_JobFailed,
+		ws:    &workspace{},
+		err:   messaging.NewError(messaging.ErrorKind_ErrorEncrypted, "pdf is encrypted"),
+	}
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	fn := writeJournal(t, dir, []*jobRecord{
+		{Uuid: "queued", State: messaging.JobState_JobQueued, Time: now},
+		{Uuid: "processing", State: messaging.JobState_JobProcessing, Time: now},
+		{Uuid: "lost", State: messaging.JobState_JobDispatched, Time: now},
+		{Uuid: "done", State: messaging.JobState_JobDone, Txt: dir + "done.txt", Cached: true, Time: now},
+		failed.record(),
+		{Uuid: "cancelled", State: messaging.JobState_JobCancelled, Time: now},
+		{Uuid: "done long ago", State: messaging.JobState_JobDone, Time: old},
+		{Uuid: "expired", State: messaging.JobState_JobExpired, Time: now},
+	})
+
+	tests := []struct {
+		uuid     string
+		restored bool
+		state    messaging.JobState
+	}{
+		{"queued", true, messaging.JobState_JobQueued},
+		{"processing", true, messaging.JobState_JobQueued},
+		{"lost", true, messaging.JobState_JobFailed},
+		{"done", true, messaging.JobState_JobDone},
+		{"failed", true, messaging.JobState_JobFailed},
+		{"cancelled", true, messaging.JobState_JobCancelled},
+		{"done long ago", false, 0},
+		{"expired", false, 0},
+	}
+
+	s := testRestartServer(t, dir, fn)
+	if n := s.queue.len(); n != 2 {
+		t.Errorf("%d jobs are queued again, expected 2", n)
+	}
+	for _, test := range tests {
+		t.Run(test.uuid, func(t *testing.T) {
+			j, ok := s.requests[test.uuid]
+			if ok != test.restored {
+				t.Fatalf("job is restored: %t, expected %t", ok, test.restored)
+			}
+			if !ok {
+				return
+			}
+			if state := j.status().State; state != test.state {
+				t.Errorf("job is %s, expected %s", state, test.state)
+			}
+		})
+	}
+
+	if j := s.requests["done"]; j.txtfn != dir+"done.txt" || !j.cached {
+		t.Errorf("done job has text %q, cached %t", j.txtfn, j.cached)
+	}
+	if detail := messaging.ErrorDetailOf(s.requests["failed"].err); detail == nil || detail.Kind != messaging.ErrorKind_ErrorEncrypted {
+		t.Errorf("failed job has error %v", s.requests["failed"].err)
+	}
+
+	// The job that expired at the restart is forgotten by the next one
+	s.store.Close()
+	records, err := readJournal(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if r.Uuid == "done long ago" && r.State != messaging.JobState_JobExpired {
+			t.Errorf("job done long ago is recorded as %s, expected expired", r.State)
+		}
+	}
+	st, records, err := openJobStore(fn, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	for _, r := range records {
+		if r.Uuid == "done long ago" || r.Uuid == "expired" {
+			t.Errorf("job %s is restored again", r.Uuid)
+		}
+	}
+}
+
+// testRestartServer returns a server restoring its jobs from the fn journal, its workspaces being in dir.
+func testRestartServer(t *testing.T, dir string, fn string) *ServerGRPC {
+	t.Helper()
+
+	st, records, err := openJobStore(fn, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ServerGRPC{
+		logger:            zerolog.Nop(),
+		store:             st,
+		queue:             newJobQueue(10, time.Minute),
+		requests:          make(map[string]*job),
+		reqmtx:            &sync.RWMutex{},
+		incomingFolder:    dir,
+		outgoingFolder:    dir,
+		maxProcessingTime: time.Minute,
+		retention: retentionPolicy{
+			result:  time.Hour,
+			failure: time.Hour,
+			expired: time.Hour,
+		},
+	}
+	s.restoreJobs(records)
+
+	return s
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// probeWorkers checks the health of every worker at once
+// and returns the number of healthy ones among the total.
+func (s *ServerGRPC) probeWorkers() (healthy int, total int) {
+	var wg sync.WaitGroup
+	workers := s.workerList()
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *workerClientGRPC) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), s.healthInterval)
+			defer cancel()
+			err := w.Check(ctx)
+			if err == nil {
+				// A worker may come back with other extractors
+				var extractors []string
+				if extractors, err = w.Capabilities(ctx); err == nil {
+					w.setExtractors(extractors)
+				}
+			}
+			s.recordProbe(w, err)
+		}(w)
+	}
+	wg.Wait()
+
+	for _, w := range workers {
+		if w.isHealthy() {
+			healthy++
+		}
+	}
+
+	return healthy, len(workers)
+}
+
+// recordProbe updates the state of the worker with the result of a probe.
+// The worker is ejected after unhealthyThreshold consecutive failures
+// and re-admitted after the first success.
+func (s *ServerGRPC) recordProbe(w *workerClientGRPC, err error) {
+	w.statemtx.Lock()
+	defer w.statemtx.Unlock()
+
+	w.lastCheck = time.Now()
+	if err == nil {
+		if !w.healthy {
+			s.logger.Info().Msg(fmt.Sprintf("worker %s has recovered: it is back in rotation", w.address))
+		}
+		w.healthy = true
+		w.failures = 0
+		w.lastError = ""
+		return
+	}
+
+	w.failures++
+	w.lastError = err.Error()
+	s.logger.Warn().Err(err).Msg(fmt.Sprintf("worker %s failed its health check (%d in a row)", w.address, w.failures))
+	if w.healthy && w.failures >= s.unhealthyThreshold {
+		w.healthy = false
+		s.logger.Error().Msg(fmt.Sprintf("worker %s is unhealthy: it is removed from rotation", w.address))
+	}
+}
+
+func (w *workerClientGRPC) isHealthy() bool {
+	w.statemtx.RLock()
+	defer w.statemtx.RUnlock()
+
+	return w.healthy
+}
+
+// info describes the worker for the admin service.
+func (w *workerClientGRPC) info() *messaging.WorkerInfo {
+	w.statemtx.RLock()
+	defer w.statemtx.RUnlock()
+
+	info := &messaging.WorkerInfo{
+		Address:             w.address,
+		Healthy:             w.healthy,
+		ConsecutiveFailures: int32(w.failures),
+		LastError:           w.lastError,
+		InFlight:            atomic.LoadInt64(&w.inflight),
+		Weight:              int32(w.weight),
+		MaxInFlight:         int32(w.maxInFlight),
+		Id:                  w.id,
+		Labels:              w.labels,
+		Extractors:          w.extractors,
+	}
+	if !w.lastCheck.IsZero() {
+		info.LastCheck, _ = ptypes.TimestampProto(w.lastCheck)
+	}
+	if !w.lastHeartbeat.IsZero() {
+		info.LastHeartbeat, _ = ptypes.TimestampProto(w.lastHeartbeat)
+	}
+
+	return info
+}
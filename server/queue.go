@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc"
+)
+
+// Levels of the queue by priority, the first level is served first
+var priorityLevels = map[messaging.Priority]int{
+	messaging.Priority_PriorityInteractive: 0,
+	messaging.Priority_PriorityNormal:      1,
+	messaging.Priority_PriorityBatch:       2,
+}
+
+// queuedJob is a job waiting for a worker
+type queuedJob struct {
+	j   *job
+	ctx context.Context
+	// level of the job priority and time it was queued at
+	level int
+	since time.Time
+}
+
+// jobQueue holds the jobs waiting for a worker, with a queue for each priority level.
+// Places are reserved by the uploads, so a full queue is known before receiving the file.
+type jobQueue struct {
+	levels [][]queuedJob
+	// maximum number of waiting and reserved jobs of each level, 0 for no limit
+	size     int
+	reserved []int
+	// waiting time after which a job gets the priority of the level above, 0 for never
+	aging time.Duration
+	mtx   *sync.Mutex
+	cond  *sync.Cond
+}
+
+func newJobQueue(size int, aging time.Duration) *jobQueue {
+	q := &jobQueue{
+		levels:   make([][]queuedJob, len(priorityLevels)),
+		size:     size,
+		reserved: make([]int, len(priorityLevels)),
+		aging:    aging,
+		mtx:      &sync.Mutex{},
+	}
+	q.cond = sync.NewCond(q.mtx)
+
+	return q
+}
+
+// reserve takes a place for a job being uploaded. It returns false if the queue
+// of the level is full, so batch traffic doesn't stop interactive uploads.
+func (q *jobQueue) reserve(level int) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.size != 0 && len(q.levels[level])+q.reserved[level] >= q.size {
+		return false
+	}
+	q.reserved[level]++
+
+	return true
+}
+
+// release gives back a reserved place if the upload failed.
+func (q *jobQueue) release(level int) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.reserved[level]--
+}
+
+// push adds a job in its reserved place, or over the limit if it has none.
+func (q *jobQueue) push(qj queuedJob, reserved bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if reserved {
+		q.reserved[qj.level]--
+	}
+	qj.since = time.Now()
+	q.levels[qj.level] = append(q.levels[qj.level], qj)
+	q.cond.Signal()
+}
+
+// wait returns once a job is waiting.
+func (q *jobQueue) wait() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for q.count() == 0 {
+		q.cond.Wait()
+	}
+}
+
+// pop waits for a job and removes it from the queue. The job of the highest
+// priority is taken, each aging period spent waiting raising a job by a level.
+// Between jobs of the same level, the oldest is taken.
+func (q *jobQueue) pop() queuedJob {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for q.count() == 0 {
+		q.cond.Wait()
+	}
+
+	now := time.Now()
+	best, bestLevel := -1, 0
+	for l, jobs := range q.levels {
+		if len(jobs) == 0 {
+			continue
+		}
+		level := q.effectiveLevel(jobs[0], now)
+		if best == -1 || level < bestLevel ||
+			level == bestLevel && jobs[0].since.Before(q.levels[best][0].since) {
+			best, bestLevel = l, level
+		}
+	}
+
+	qj := q.levels[best][0]
+	q.levels[best][0] = queuedJob{}
+	q.levels[best] = q.levels[best][1:]
+
+	return qj
+}
+
+// effectiveLevel returns the level of a queued job raised by its waiting time.
+func (q *jobQueue) effectiveLevel(qj queuedJob, now time.Time) int {
+	if q.aging <= 0 {
+		return qj.level
+	}
+	level := qj.level - int(now.Sub(qj.since)/q.aging)
+	if level < 0 {
+		level = 0
+	}
+
+	return level
+}
+
+// count returns the number of waiting jobs. It must be called with the queue lock held.
+func (q *jobQueue) count() (n int) {
+	for _, jobs := range q.levels {
+		n += len(jobs)
+	}
+
+	return
+}
+
+func (q *jobQueue) len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return q.count()
+}
+
+// enqueue queues the job for processing. Its workspace is held from now on
+// by the processing, so the pdf can't be removed before the workers read it.
+// The job fails if no worker takes it before its deadline.
+func (s *ServerGRPC) enqueue(ctx context.Context, j *job, level int, reserved bool) {
+	j.ws.hold()
+	s.queue.push(queuedJob{j: j, ctx: ctx, level: level}, reserved)
+	if !j.deadline.IsZero() {
+		time.AfterFunc(time.Until(j.deadline), j.timeout)
+	}
+}
+
+// runQueue dispatches the queued jobs one after the other. A job is only taken
+// once a worker has room for it, so a job queued meanwhile with a higher priority goes first.
+func (s *ServerGRPC) runQueue() {
+	for {
+		s.queue.wait()
+		w := s.waitWorker(context.Background(), "")
+		qj := s.queue.pop()
+
+		if qj.ctx.Err() != nil {
+			// Cancelled or timed out while waiting
+			s.release(w)
+			qj.j.ws.release()
+			continue
+		}
+
+		go s.process(qj.ctx, qj.j, w)
+	}
+}
+
+// waitWorker returns a worker with room for a job running the extractorName extractor,
+// or nil once the ctx is cancelled.
+func (s *ServerGRPC) waitWorker(ctx context.Context, extractorName string) *workerClientGRPC {
+	for {
+		w, err := s.nextWorker(extractorName)
+		if err == nil {
+			return w
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.freed:
+		case <-time.After(s.healthInterval):
+			s.logger.Debug().Msg(fmt.Sprintf("%d jobs are waiting for a worker: %s", s.queue.len(), err))
+		}
+	}
+}
+
+// release ends a request given to the worker and wakes up the queue.
+func (s *ServerGRPC) release(w *workerClientGRPC) {
+	w.end()
+
+	select {
+	case s.freed <- struct{}{}:
+	default:
+	}
+}
+
+// overloaded returns the error telling a client to come back later.
+func (s *ServerGRPC) overloaded(format string, args ...interface{}) error {
+	return messaging.NewOverloadedError(s.retryAfter, format, args...)
+}
+
+// withRetryAfter sets the retry-after trailer of the stream
+// if err tells the client to try again later.
+func withRetryAfter(stream grpc.ServerStream, err error) error {
+	if md := messaging.RetryAfterTrailer(err); md != nil {
+		stream.SetTrailer(md)
+	}
+
+	return err
+}
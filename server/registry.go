@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RegisterWorker implements RegisterWorker method of PdftotextAdmin. A worker registering
+// again with a known address keeps its id, its capacity, labels and extractors are updated.
+// The worker must give the registration token of the server, as it is then sent documents.
+func (s *ServerGRPC) RegisterWorker(ctx context.Context, reg *messaging.WorkerRegistration) (id *messaging.WorkerId, err error) {
+	if err = messaging.CheckRegistrationToken(ctx, s.registrationToken); err != nil {
+		return
+	}
+	if reg.Address == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "address of the worker must be set")
+	}
+	if reg.Capacity < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "capacity of the worker must be positive")
+	}
+	weight := int(reg.Capacity)
+	if weight == 0 {
+		weight = 1
+	}
+
+	s.workermtx.Lock()
+	defer s.workermtx.Unlock()
+
+	for _, w := range s.workers {
+		if w.address != reg.Address {
+			continue
+		}
+		if w.id == "" {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"worker %s is given at startup", reg.Address)
+		}
+		w.weight = weight
+		w.maxInFlight = int64(weight)
+		w.heartbeat(reg.Labels)
+		w.setExtractors(reg.Extractors)
+		s.logger.Info().Msg(fmt.Sprintf("worker %s has registered again", w.address))
+		return &messaging.WorkerId{Id: w.id}, nil
+	}
+
+	w, err := newWorkerClientGRPC(workerClientGRPCConfig{
+		Address:         reg.Address,
+		Weight:          weight,
+		MaxInFlight:     weight,
+		ChunkSize:       s.chunkSize,
+		RootCertificate: s.certificate,
+		Compress:        s.compress,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to add worker %s: %s", reg.Address, err)
+	}
+	w.id = uuid.New().String()
+	w.heartbeat(reg.Labels)
+	w.setExtractors(reg.Extractors)
+	s.workers = append(s.workers, &w)
+
+	s.logger.Info().Msg(fmt.Sprintf("worker %s has registered with capacity %d", w.address, weight))
+
+	return &messaging.WorkerId{Id: w.id}, nil
+}
+
+// Heartbeat implements Heartbeat method of PdftotextAdmin. It needs the registration token as well.
+func (s *ServerGRPC) Heartbeat(ctx context.Context, id *messaging.WorkerId) (reply *messaging.HeartbeatReply, err error) {
+	if err = messaging.CheckRegistrationToken(ctx, s.registrationToken); err != nil {
+		return
+	}
+
+	s.workermtx.RLock()
+	defer s.workermtx.RUnlock()
+
+	for _, w := range s.workers {
+		if w.id != "" && w.id == id.Id {
+			w.heartbeat(nil)
+			return &messaging.HeartbeatReply{
+				Timeout: ptypes.DurationProto(s.heartbeatTimeout),
+			}, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.NotFound, "worker %s is not registered", id.Id)
+}
+
+// dropSilentWorkers periodically removes the registered workers
+// that missed their heartbeats. Workers given at startup are kept.
+func (s *ServerGRPC) dropSilentWorkers() {
+	for {
+		time.Sleep(s.heartbeatTimeout / 2)
+
+		s.workermtx.Lock()
+		workers := s.workers[:0]
+		for _, w := range s.workers {
+			if w.id == "" || time.Since(w.lastSeen()) < s.heartbeatTimeout {
+				workers = append(workers, w)
+				continue
+			}
+			s.logger.Warn().Msg(fmt.Sprintf("worker %s missed its heartbeats: it is dropped", w.address))
+			// Requests in flight fail and are retried on another worker
+			w.Close()
+		}
+		// Don't keep the dropped workers alive via the end of the slice
+		for i := len(workers); i < len(s.workers); i++ {
+			s.workers[i] = nil
+		}
+		s.workers = workers
+		s.workermtx.Unlock()
+	}
+}
+
+// workerList returns the current workers.
+func (s *ServerGRPC) workerList() []*workerClientGRPC {
+	s.workermtx.RLock()
+	defer s.workermtx.RUnlock()
+
+	return append([]*workerClientGRPC(nil), s.workers...)
+}
+
+// heartbeat records that the worker is alive, with its new labels if any.
+func (c *workerClientGRPC) heartbeat(labels map[string]string) {
+	c.statemtx.Lock()
+	defer c.statemtx.Unlock()
+
+	c.lastHeartbeat = time.Now()
+	if labels != nil {
+		c.labels = labels
+	}
+}
+
+func (c *workerClientGRPC) lastSeen() time.Time {
+	c.statemtx.RLock()
+	defer c.statemtx.RUnlock()
+
+	return c.lastHeartbeat
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryPolicy tells how a failed dispatch is tried again on another worker
+type retryPolicy struct {
+	// attempts, the first one included
+	maxAttempts int
+	// delay before the first retry, doubled for each following one
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// delay returns the time to wait before the retry following the given attempt.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+
+	// Spread the retries of jobs that failed together
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+// retryable tells whether a failure of the worker may not happen with another one.
+// Failures caused by the file itself, like an invalid pdf, are permanent.
+func retryable(err error) bool {
+	if detail := messaging.ErrorDetailOf(err); detail != nil {
+		// A corrupted transfer may go well the next time
+		return detail.Kind == messaging.ErrorKind_ErrorWorkerUnavailable ||
+			detail.Kind == messaging.ErrorKind_ErrorCorrupted
+	}
+
+	st, ok := status.FromError(errors.Cause(err))
+	if !ok {
+		return false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.Aborted, codes.Unknown, codes.Internal:
+		// The worker is down, has crashed or has reset the stream
+		return true
+	}
+
+	return false
+}
+
+// dispatch calls call with the w worker, then with other healthy workers running
+// the extractorName extractor as long as the failure is retryable and the retry
+// policy allows it. The workers are released after their call.
+func (s *ServerGRPC) dispatch(
+	ctx context.Context,
+	w *workerClientGRPC,
+	extractorName string,
+	call func(w *workerClientGRPC, attempt int) error) (err error) {
+	tried := make(map[*workerClientGRPC]bool)
+
+	for attempt := 1; ; attempt++ {
+		err = call(w, attempt)
+		s.release(w)
+		if err == nil || !retryable(err) || attempt >= s.retry.maxAttempts {
+			return
+		}
+		tried[w] = true
+
+		delay := s.retry.delay(attempt)
+		s.logger.Warn().Err(err).Msg(fmt.Sprintf("worker %s failed: retrying in %s (attempt %d/%d)",
+			w.address, delay, attempt+1, s.retry.maxAttempts))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		next, nextErr := s.nextWorkerExcept(tried, extractorName)
+		if nextErr != nil {
+			// The failure of the last attempt is more helpful
+			return
+		}
+		w = next
+	}
+}
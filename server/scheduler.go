@@ -0,0 +1,157 @@
+package server
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Scheduler picks the worker a request is dispatched to.
+type Scheduler interface {
+	// Next returns one of the candidates, which are healthy workers.
+	// It is never called without candidate.
+	Next(candidates []*workerClientGRPC) *workerClientGRPC
+}
+
+// Names of the available schedulers
+const (
+	SchedulerRoundRobin         = "round-robin"
+	SchedulerLeastOutstanding   = "least-outstanding"
+	SchedulerWeightedRoundRobin = "weighted-round-robin"
+	SchedulerPowerOfTwoChoices  = "p2c"
+)
+
+//NewScheduler function returns the scheduler with the given name.
+func NewScheduler(name string) (Scheduler, error) {
+	switch name {
+	case "", SchedulerRoundRobin:
+		return &roundRobin{}, nil
+	case SchedulerLeastOutstanding:
+		return leastOutstanding{}, nil
+	case SchedulerWeightedRoundRobin:
+		return &weightedRoundRobin{
+			current: make(map[*workerClientGRPC]int),
+			mtx:     &sync.Mutex{},
+		}, nil
+	case SchedulerPowerOfTwoChoices:
+		return powerOfTwoChoices{}, nil
+	}
+
+	return nil, errors.Errorf("unknown scheduler %s", name)
+}
+
+// roundRobin gives the candidates a request in turn
+type roundRobin struct {
+	count uint64
+}
+
+func (r *roundRobin) Next(candidates []*workerClientGRPC) *workerClientGRPC {
+	i := atomic.AddUint64(&r.count, 1) - 1
+
+	return candidates[i%uint64(len(candidates))]
+}
+
+// leastOutstanding picks the candidate with the least in-flight requests
+// relative to its weight
+type leastOutstanding struct{}
+
+func (leastOutstanding) Next(candidates []*workerClientGRPC) *workerClientGRPC {
+	best := candidates[0]
+	for _, w := range candidates[1:] {
+		if w.load() < best.load() {
+			best = w
+		}
+	}
+
+	return best
+}
+
+// weightedRoundRobin gives the candidates a number of requests proportional
+// to their weight, interleaved as in the smooth weighted round-robin of nginx
+type weightedRoundRobin struct {
+	current map[*workerClientGRPC]int
+	mtx     *sync.Mutex
+}
+
+func (r *weightedRoundRobin) Next(candidates []*workerClientGRPC) *workerClientGRPC {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var (
+		best    *workerClientGRPC
+		total   int
+		current = make(map[*workerClientGRPC]int, len(candidates))
+	)
+	for _, w := range candidates {
+		current[w] = r.current[w] + w.weight
+		total += w.weight
+		if best == nil || current[w] > current[best] {
+			best = w
+		}
+	}
+	current[best] -= total
+	// Workers that are not candidates anymore, dropped ones included, are forgotten
+	r.current = current
+
+	return best
+}
+
+// powerOfTwoChoices picks two random candidates and keeps the least loaded one
+type powerOfTwoChoices struct{}
+
+func (powerOfTwoChoices) Next(candidates []*workerClientGRPC) *workerClientGRPC {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if candidates[j].load() < candidates[i].load() {
+		return candidates[j]
+	}
+
+	return candidates[i]
+}
+
+// parseWorkerAddress splits a worker given as address@weight.
+// The weight is 1 if it is omitted.
+func parseWorkerAddress(s string) (address string, weight int, err error) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return s, 1, nil
+	}
+
+	weight, err = strconv.Atoi(s[i+1:])
+	if err != nil || weight < 1 {
+		err = errors.Errorf("weight of worker %s must be a positive integer", s)
+		return
+	}
+
+	return s[:i], weight, nil
+}
+
+// begin and end count the requests in flight on the worker
+func (c *workerClientGRPC) begin() {
+	atomic.AddInt64(&c.inflight, 1)
+}
+
+func (c *workerClientGRPC) end() {
+	atomic.AddInt64(&c.inflight, -1)
+}
+
+// available tells whether the worker has room for one more request.
+func (c *workerClientGRPC) available() bool {
+	return atomic.LoadInt64(&c.inflight) < c.maxInFlight
+}
+
+// load returns the number of requests in flight per unit of weight.
+func (c *workerClientGRPC) load() float64 {
+	return float64(atomic.LoadInt64(&c.inflight)) / float64(c.weight)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// testWorker returns a healthy worker of the given weight, having inflight requests
+// in flight out of maxInFlight.
+func testWorker(address string, weight int, inflight int64, maxInFlight int64) *workerClientGRPC {
+	return &workerClientGRPC{
+		address:     address,
+		weight:      weight,
+		inflight:    inflight,
+		maxInFlight: maxInFlight,
+		healthy:     true,
+		statemtx:    &sync.RWMutex{},
+	}
+}
+
+// picks returns the addresses of the workers picked by n calls of the scheduler.
+func picks(scheduler Scheduler, candidates []*workerClientGRPC, n int) string {
+	var addresses []string
+	for i := 0; i < n; i++ {
+		addresses = append(addresses, scheduler.Next(candidates).address)
+	}
+
+	return strings.Join(addresses, " ")
+}
+
+func newTestScheduler(t *testing.T, name string) Scheduler {
+	t.Helper()
+
+	scheduler, err := NewScheduler(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scheduler
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b, c := testWorker("a", 1, 0, 1), testWorker("b", 1, 0, 1), testWorker("c", 1, 0, 1)
+	scheduler := newTestScheduler(t, SchedulerRoundRobin)
+
+	if p := picks(scheduler, []*workerClientGRPC{a, b, c}, 6); p != "a b c a b c" {
+		t.Errorf("picks are %s", p)
+	}
+	// The turn goes on among the remaining candidates
+	if p := picks(scheduler, []*workerClientGRPC{a, c}, 3); p != "a c a" {
+		t.Errorf("picks without b are %s", p)
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []*workerClientGRPC
+		expected   string
+	}{
+		{"least requests", []*workerClientGRPC{testWorker("a", 1, 3, 4), testWorker("b", 1, 1, 4), testWorker("c", 1, 2, 4)}, "b"},
+		{"relative to the weight", []*workerClientGRPC{testWorker("a", 1, 2, 4), testWorker("b", 4, 4, 8)}, "b"},
+		{"first of equals", []*workerClientGRPC{testWorker("a", 1, 1, 4), testWorker("b", 1, 1, 4)}, "a"},
+	}
+	scheduler := newTestScheduler(t, SchedulerLeastOutstanding)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if w := scheduler.Next(test.candidates); w.address != test.expected {
+				t.Errorf("picked %s, expected %s", w.address, test.expected)
+			}
+		})
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	a, b, c := testWorker("a", 5, 0, 1), testWorker("b", 1, 0, 1), testWorker("c", 1, 0, 1)
+	scheduler := newTestScheduler(t, SchedulerWeightedRoundRobin)
+
+	// Smooth: the picks of the heaviest worker are spread over the cycle
+	if p := picks(scheduler, []*workerClientGRPC{a, b, c}, 7); p != "a a b a c a a" {
+		t.Errorf("picks are %s", p)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 70; i++ {
+		counts[scheduler.Next([]*workerClientGRPC{a, b, c}).address]++
+	}
+	if counts["a"] != 50 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Errorf("picks are distributed as %v, expected 50/10/10", counts)
+	}
+
+	// A worker leaving the candidates is forgotten, and starts over when it comes back
+	scheduler.Next([]*workerClientGRPC{a, c})
+	if r := scheduler.(*weightedRoundRobin); len(r.current) != 2 || r.current[b] != 0 {
+		t.Errorf("state is kept for %d workers, b having %d", len(r.current), r.current[b])
+	}
+	counts = make(map[string]int)
+	for i := 0; i < 7; i++ {
+		counts[scheduler.Next([]*workerClientGRPC{a, b, c}).address]++
+	}
+	if counts["a"] != 5 || counts["b"] != 1 || counts["c"] != 1 {
+		t.Errorf("picks once b is back are distributed as %v, expected 5/1/1", counts)
+	}
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	scheduler := newTestScheduler(t, SchedulerPowerOfTwoChoices)
+
+	// Two candidates are always compared
+	idle, busy := testWorker("idle", 1, 0, 4), testWorker("busy", 1, 3, 4)
+	if p := picks(scheduler, []*workerClientGRPC{busy, idle}, 5); p != "idle idle idle idle idle" {
+		t.Errorf("picks are %s", p)
+	}
+	if w := scheduler.Next([]*workerClientGRPC{busy}); w != busy {
+		t.Errorf("picked %s out of a single candidate", w.address)
+	}
+
+	// The most loaded worker loses any comparison
+	candidates := []*workerClientGRPC{testWorker("a", 1, 1, 4), testWorker("b", 1, 2, 4), busy, testWorker("c", 2, 2, 4)}
+	for i := 0; i < 100; i++ {
+		if w := scheduler.Next(candidates); w == busy {
+			t.Fatal("picked the most loaded worker")
+		}
+	}
+}
+
+func TestNextWorkerSaturation(t *testing.T) {
+	a, b := testWorker("a", 1, 0, 1), testWorker("b", 1, 0, 2)
+	s := &ServerGRPC{
+		workers:   []*workerClientGRPC{a, b},
+		workermtx: &sync.RWMutex{},
+		scheduler: newTestScheduler(t, SchedulerRoundRobin),
+	}
+
+	var picked []string
+	for i := 0; i < 3; i++ {
+		w, err := s.nextWorker("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked = append(picked, w.address)
+	}
+	if p := strings.Join(picked, " "); p != "a b b" {
+		t.Errorf("picks are %s, expected a b b", p)
+	}
+
+	// Full workers are not candidates
+	_, err := s.nextWorker("")
+	if detail := messaging.ErrorDetailOf(err); detail == nil || detail.Kind != messaging.ErrorKind_ErrorOverloaded {
+		t.Errorf("error is %v once the workers are full, expected an overload", err)
+	}
+
+	// A released worker is a candidate again, the tried ones only as a fallback
+	b.end()
+	a.end()
+	w, err := s.nextWorkerExcept(map[*workerClientGRPC]bool{a: true}, "")
+	if err != nil || w != b {
+		t.Errorf("picked %v, %v, expected b", w, err)
+	}
+	w, err = s.nextWorkerExcept(map[*workerClientGRPC]bool{a: true}, "")
+	if err != nil || w != a {
+		t.Errorf("picked %v, %v, expected the tried a", w, err)
+	}
+
+	// Unhealthy workers are not candidates
+	a.healthy, b.healthy = false, false
+	_, err = s.nextWorker("")
+	if detail := messaging.ErrorDetailOf(err); detail == nil || detail.Kind != messaging.ErrorKind_ErrorWorkerUnavailable {
+		t.Errorf("error is %v without healthy worker, expected an unavailability", err)
+	}
+}
+
+func TestParseWorkerAddress(t *testing.T) {
+	tests := []struct {
+		s       string
+		address string
+		weight  int
+		valid   bool
+	}{
+		{"localhost:8001", "localhost:8001", 1, true},
+		{"localhost:8001@3", "localhost:8001", 3, true},
+		{"user@host:8001@2", "user@host:8001", 2, true},
+		{"localhost:8001@0", "", 0, false},
+		{"localhost:8001@-1", "", 0, false},
+		{"localhost:8001@heavy", "", 0, false},
+		{"localhost:8001@", "", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			address, weight, err := parseWorkerAddress(test.s)
+			if (err == nil) != test.valid {
+				t.Fatalf("error is %v, expected valid: %t", err, test.valid)
+			}
+			if test.valid && (address != test.address || weight != test.weight) {
+				t.Errorf("worker is %s with weight %d, expected %s with weight %d", address, weight, test.address, test.weight)
+			}
+		})
+	}
+}
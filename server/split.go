@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"golang.org/x/sync/errgroup"
+)
+
+// pageRange is a part of a document processed by a single worker
+type pageRange struct {
+	first, last int32
+}
+
+// pageRanges returns the page ranges the job is split into, or nil if it is processed
+// as a whole: splitting is disabled, the document is too short or has an unknown
+// number of pages, or a single worker is healthy.
+func (s *ServerGRPC) pageRanges(ctx context.Context, j *job) []pageRange {
+	if s.splitMinPages == 0 {
+		return nil
+	}
+	workers := s.healthyWorkers(j.opts.Extractor)
+	if workers < 2 {
+		return nil
+	}
+
+	meta, err := s.pdfinfo(ctx, j.ws.pdffn)
+	if err != nil {
+		// The worker will tell what is wrong with the file
+		s.logger.Warn().Err(err).Msg(fmt.Sprintf("%s: number of pages is unknown, the file is not split", j.uuid))
+		return nil
+	}
+
+	return splitRange(j.opts.FirstPage, j.opts.LastPage, meta.Pages, s.splitMinPages, workers)
+}
+
+// splitRange returns the page ranges the pages from first to last of a document of the given
+// number of pages are split into among the workers, or nil if they are less than minPages.
+// first and last are the ones of the extraction options, 0 for the first and last pages.
+func splitRange(first, last, pages int32, minPages int, workers int) []pageRange {
+	if first == 0 {
+		first = 1
+	}
+	if last == 0 || last > pages {
+		last = pages
+	}
+	n := int(last - first + 1)
+	if n < minPages {
+		return nil
+	}
+
+	parts := workers
+	if parts > n {
+		parts = n
+	}
+
+	return splitPages(first, last, parts)
+}
+
+// splitPages divides the pages from first to last into parts ranges of about the same size.
+func splitPages(first, last int32, parts int) (ranges []pageRange) {
+	pages := int(last - first + 1)
+	for i := 0; i < parts; i++ {
+		ranges = append(ranges, pageRange{
+			first: first + int32(pages*i/parts),
+			last:  first + int32(pages*(i+1)/parts) - 1,
+		})
+	}
+
+	return
+}
+
+// processParts extracts the page ranges of the job in parallel, each one being
+// dispatched and retried on its own, then merges their texts in page order into txtfn.
+// The w worker gets the first range, the other ones wait for a worker with room.
+func (s *ServerGRPC) processParts(ctx context.Context, j *job, w *workerClientGRPC, ranges []pageRange, txtfn string) (err error) {
+	msg := fmt.Sprintf("File is split into %d parts dispatched to the workers", len(ranges))
+	if serr := j.setState(messaging.JobState_JobDispatched, msg); serr != nil {
+		s.logger.Error().Err(serr).Msg("failed to update job state")
+	}
+	s.logger.Info().Msg(fmt.Sprintf("%s: %s", j.uuid, msg))
+
+	partfns := make([]string, len(ranges))
+	defer func() {
+		for _, partfn := range partfns {
+			os.Remove(partfn)
+		}
+	}()
+
+	// A part failing for good stops the other ones
+	errg, gctx := errgroup.WithContext(ctx)
+	for i, r := range ranges {
+		i, r := i, r
+		partfns[i] = j.ws.partFile(i)
+
+		pw := w
+		if i > 0 {
+			pw = s.waitWorker(gctx, j.opts.Extractor)
+			if pw == nil {
+				break
+			}
+		}
+
+		opts := proto.Clone(j.opts).(*messaging.ExtractionOptions)
+		opts.FirstPage = r.first
+		opts.LastPage = r.last
+		errg.Go(func() error {
+			return s.dispatch(gctx, pw, j.opts.Extractor, func(pw *workerClientGRPC, attempt int) error {
+				if attempt > 1 {
+					s.logger.Info().Msg(fmt.Sprintf("%s: pages %d to %d are dispatched to another worker (attempt %d)",
+						j.uuid, r.first, r.last, attempt))
+				}
+				return pw.PdfToTextFile(gctx, nil, j.ws.pdffn, opts, partfns[i])
+			})
+		})
+	}
+	if serr := j.setState(messaging.JobState_JobProcessing, "File is being processed by the workers"); serr != nil {
+		s.logger.Error().Err(serr).Msg("failed to update job state")
+	}
+
+	err = errg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return
+	}
+
+	return mergeParts(txtfn, partfns)
+}
+
+// mergeParts concatenates the texts of the partfns files into txtfn.
+func mergeParts(txtfn string, partfns []string) (err error) {
+	file, err := os.Create(txtfn)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create result file %s",
+			txtfn)
+		return
+	}
+	defer file.Close()
+
+	for _, partfn := range partfns {
+		err = appendFile(file, partfn)
+		if err != nil {
+			return
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to write result file %s",
+			txtfn)
+		return
+	}
+
+	return
+}
+
+func appendFile(w io.Writer, fn string) (err error) {
+	part, err := os.Open(fn)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to open part %s",
+			fn)
+		return
+	}
+	defer part.Close()
+
+	_, err = io.Copy(w, part)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to copy part %s",
+			fn)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPages(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, last int32
+		parts       int
+		expected    []pageRange
+	}{
+		{"even", 1, 10, 2, []pageRange{{1, 5}, {6, 10}}},
+		{"uneven", 1, 10, 3, []pageRange{{1, 3}, {4, 6}, {7, 10}}},
+		{"uneven from a later page", 5, 11, 4, []pageRange{{5, 5}, {6, 7}, {8, 9}, {10, 11}}},
+		{"as many parts as pages", 3, 5, 3, []pageRange{{3, 3}, {4, 4}, {5, 5}}},
+		{"single part", 1, 7, 1, []pageRange{{1, 7}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges := splitPages(test.first, test.last, test.parts)
+			if !reflect.DeepEqual(ranges, test.expected) {
+				t.Errorf("ranges are %v, expected %v", ranges, test.expected)
+			}
+		})
+	}
+}
+
+func TestSplitRange(t *testing.T) {
+	tests := []struct {
+		name               string
+		first, last, pages int32
+		minPages, workers  int
+		expected           []pageRange
+	}{
+		{"whole document", 0, 0, 10, 4, 2, []pageRange{{1, 5}, {6, 10}}},
+		{"from the first page asked", 3, 0, 10, 4, 2, []pageRange{{3, 6}, {7, 10}}},
+		{"up to the last page asked", 0, 6, 10, 4, 2, []pageRange{{1, 3}, {4, 6}}},
+		{"last page beyond the document", 5, 20, 10, 4, 2, []pageRange{{5, 7}, {8, 10}}},
+		{"fewer pages than workers", 0, 0, 3, 2, 8, []pageRange{{1, 1}, {2, 2}, {3, 3}}},
+		{"too short", 0, 0, 3, 4, 2, nil},
+		{"too short once clamped", 8, 20, 10, 4, 2, nil},
+		{"first page beyond the document", 12, 0, 10, 1, 2, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges := splitRange(test.first, test.last, test.pages, test.minPages, test.workers)
+			if !reflect.DeepEqual(ranges, test.expected) {
+				t.Errorf("ranges are %v, expected %v", ranges, test.expected)
+			}
+		})
+	}
+}
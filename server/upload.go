@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//CreateUpload implements CreateUpload method of PdftotextService. The partial file
+//of the upload is kept in the incoming folder until UploadPdf completes it.
+func (s *ServerGRPC) CreateUpload(ctx context.Context, req *messaging.NewUpload) (offset *messaging.UploadOffset, err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
+
+	if s.maxFileSize != 0 && req.Size > s.maxFileSize {
+		return nil, messaging.NewError(messaging.ErrorKind_ErrorTooLarge,
+			"file is larger than %d bytes", s.maxFileSize)
+	}
+
+	id := uuid.New().String()
+	file, err := os.Create(s.uploadFile(id))
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create upload %s",
+			id)
+		return
+	}
+	file.Close()
+
+	s.logger.Info().Msg(fmt.Sprintf("upload %s of %d bytes is created", id, req.Size))
+
+	return &messaging.UploadOffset{
+		UploadId: id,
+	}, nil
+}
+
+//QueryUploadOffset implements QueryUploadOffset method of PdftotextService.
+func (s *ServerGRPC) QueryUploadOffset(ctx context.Context, id *messaging.UploadId) (offset *messaging.UploadOffset, err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
+
+	fn, err := s.lookupUpload(id.Id)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(fn)
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "upload %s is not found", id.Id)
+	}
+	if err != nil {
+		return
+	}
+
+	return &messaging.UploadOffset{
+		UploadId: id.Id,
+		Offset:   info.Size(),
+	}, nil
+}
+
+// uploadFile returns the partial file of the upload.
+func (s *ServerGRPC) uploadFile(id string) string {
+	return s.incomingFolder + "upload" + id + ".part"
+}
+
+// lookupUpload returns the partial file of the upload, checking that the id
+// can't point outside the incoming folder.
+func (s *ServerGRPC) lookupUpload(id string) (fn string, err error) {
+	if _, err = uuid.Parse(id); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "upload id %s is not valid", id)
+	}
+
+	return s.uploadFile(id), nil
+}
+
+// resumeUpload appends the chunks of the stream to the partial file of the upload,
+// skipping the content received before. The complete file is moved to fn once
+// the client has sent it all.
+func (s *ServerGRPC) resumeUpload(stream messaging.ChunkReceiver, id string, fn string) (err error) {
+	partfn, err := s.lookupUpload(id)
+	if err != nil {
+		return
+	}
+
+	// A client resuming too early finds the failed stream still running
+	s.uploadmtx.Lock()
+	if s.uploading[id] {
+		s.uploadmtx.Unlock()
+		return status.Errorf(codes.Aborted, "upload %s is already in progress", id)
+	}
+	s.uploading[id] = true
+	s.uploadmtx.Unlock()
+	defer func() {
+		s.uploadmtx.Lock()
+		delete(s.uploading, id)
+		s.uploadmtx.Unlock()
+	}()
+
+	file, err := os.OpenFile(partfn, os.O_RDWR|os.O_APPEND, 0)
+	if os.IsNotExist(err) {
+		return status.Errorf(codes.NotFound, "upload %s is not found", id)
+	}
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to open upload %s",
+			id)
+		return
+	}
+	defer file.Close()
+
+	// The trailer of the stream carries the digest of the whole file
+	h := sha256.New()
+	received, err := io.Copy(h, file)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to read upload %s",
+			id)
+		return
+	}
+
+	trailer := false
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed unexpectadely while reading chunks from stream")
+		}
+
+		if trailer {
+			return messaging.NewError(messaging.ErrorKind_ErrorCorrupted,
+				"content is received after the trailer")
+		}
+		if messaging.IsTrailer(chunk) {
+			trailer = true
+			if err = messaging.VerifyTrailer(chunk, h.Sum(nil)); err != nil {
+				// The partial file can't be trusted anymore
+				os.Remove(partfn)
+				return err
+			}
+			continue
+		}
+		if err = messaging.VerifyChunk(chunk); err != nil {
+			return err
+		}
+
+		end := chunk.Offset + int64(len(chunk.Content))
+		switch {
+		case chunk.Offset > received:
+			return status.Errorf(codes.FailedPrecondition,
+				"chunk at offset %d while %d bytes of upload %s are received", chunk.Offset, received, id)
+		case end <= received:
+			// Already received before the failure
+			continue
+		case s.maxFileSize != 0 && end > s.maxFileSize:
+			return messaging.NewError(messaging.ErrorKind_ErrorTooLarge,
+				"file is larger than %d bytes", s.maxFileSize)
+		}
+
+		content := chunk.Content[received-chunk.Offset:]
+		_, err = file.Write(content)
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed to write into upload %s",
+				id)
+		}
+		h.Write(content)
+		received = end
+	}
+
+	file.Close()
+	err = os.Rename(partfn, fn)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to complete upload %s",
+			id)
+		return
+	}
+	s.logger.Info().Msg(fmt.Sprintf("upload %s is complete: %d bytes", id, received))
+
+	return
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Duration after which an abandoned resumable upload is removed at startup
+const uploadTTL = 24 * time.Hour
+
+// Prefix of the files of the workspaces, followed by the id of the job
+const workspacePrefix = "pdftotext"
+
+// workspace owns the files of a job: the uploaded pdf in the incoming folder
+// and the extracted text in the outgoing one. They are removed once the last
+// holder releases the workspace: the job holds it until its result expires or
+// it is deleted, and its processing until the workers are done reading the pdf.
+type workspace struct {
+	pdffn string
+	txtfn string
+	refs  int
+	mtx   *sync.Mutex
+}
+
+// newWorkspace returns the workspace of the job with the given id, held once by the caller.
+func (s *ServerGRPC) newWorkspace(uuid string) *workspace {
+	return &workspace{
+		pdffn: s.incomingFolder + workspacePrefix + uuid + ".pdf",
+		txtfn: s.outgoingFolder + workspacePrefix + uuid + ".txt",
+		refs:  1,
+		mtx:   &sync.Mutex{},
+	}
+}
+
+// partFile returns the file receiving the text of the i-th part of a split job.
+func (ws *workspace) partFile(i int) string {
+	return fmt.Sprintf("%s.part%d.txt", strings.TrimSuffix(ws.txtfn, ".txt"), i)
+}
+
+// hold must be balanced by a release.
+func (ws *workspace) hold() {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+
+	ws.refs++
+}
+
+// release removes the files of the workspace once nobody holds it anymore.
+func (ws *workspace) release() {
+	ws.mtx.Lock()
+	defer ws.mtx.Unlock()
+
+	ws.refs--
+	if ws.refs > 0 {
+		return
+	}
+	os.Remove(ws.pdffn)
+	os.Remove(ws.txtfn)
+}
+
+// sweepWorkspaces removes the files left in the incoming and outgoing folders
+// by jobs the server doesn't know, e.g. after a crash. It must be called once
+// the jobs are restored, before serving.
+func (s *ServerGRPC) sweepWorkspaces() {
+	removed := 0
+	for _, dir := range []string{s.incomingFolder, s.outgoingFolder} {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			s.logger.Error().Err(err).Msg(fmt.Sprintf("failed to sweep folder %s", dir))
+			continue
+		}
+		for _, info := range infos {
+			if info.Mode().IsRegular() && s.orphaned(info) && os.Remove(dir+info.Name()) == nil {
+				removed++
+			}
+		}
+	}
+
+	if removed > 0 {
+		s.logger.Info().Msg(fmt.Sprintf("%d orphaned files removed", removed))
+	}
+}
+
+// orphaned tells whether the file belongs to no job. Partial uploads can be
+// resumed after a restart, so they are only removed once abandoned for uploadTTL.
+// Files not named by the server are left alone.
+func (s *ServerGRPC) orphaned(info os.FileInfo) bool {
+	name := info.Name()
+	if strings.HasPrefix(name, "upload") && strings.HasSuffix(name, ".part") {
+		return time.Since(info.ModTime()) > uploadTTL
+	}
+
+	id := strings.TrimPrefix(name, workspacePrefix)
+	if id == name || len(id) < 36 {
+		return false
+	}
+	id = id[:36]
+	if _, err := uuid.Parse(id); err != nil {
+		return false
+	}
+	_, known := s.requests[id]
+
+	return !known
+}
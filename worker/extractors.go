@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+)
+
+// availableExtractors returns the extractors of the given names that can run on this host,
+// in the same order. Unknown names are an error, unavailable extractors are only logged.
+func (s *WorkerServerGRPC) availableExtractors(names []string) (extractors []extractor.Extractor, err error) {
+	for _, name := range names {
+		e, err := extractor.Lookup(name)
+		if err != nil {
+			return nil, errors.Errorf("extractor %s is unknown, expected one of %v", name, extractor.Names())
+		}
+		if err = e.Available(); err != nil {
+			s.logger.Warn().Err(err).Msg(fmt.Sprintf("extractor %s is not available", name))
+			continue
+		}
+		extractors = append(extractors, e)
+	}
+	if len(extractors) == 0 {
+		return nil, errors.Errorf("none of the extractors %v is available", names)
+	}
+
+	return
+}
+
+// extractor returns the extractor asked by the options, the default one of the worker if none is.
+func (s *WorkerServerGRPC) extractor(opts *messaging.ExtractionOptions) (e extractor.Extractor, err error) {
+	e = s.extractors[0]
+	if opts.Extractor != "" {
+		e = nil
+		for _, available := range s.extractors {
+			if available.Name() == opts.Extractor {
+				e = available
+			}
+		}
+	}
+	if e == nil {
+		return nil, messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+			"extractor %s is not available on this worker", opts.Extractor)
+	}
+
+	return e, e.Check(opts)
+}
+
+// extractorNames returns the names of the extractors of the worker, its default one first.
+func (s *WorkerServerGRPC) extractorNames() (names []string) {
+	for _, e := range s.extractors {
+		names = append(names, e.Name())
+	}
+
+	return
+}
+
+// GetCapabilities implements the GetCapabilities method of the PdftotextWorker interface.
+func (s *WorkerServerGRPC) GetCapabilities(ctx context.Context, req *messaging.CapabilitiesRequest) (*messaging.Capabilities, error) {
+	return &messaging.Capabilities{
+		Extractors: s.extractorNames(),
+	}, nil
+}
@@ -1,19 +1,25 @@
 package worker
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"os"
-	"os/exec"
 	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	"gitlab.com/gaydamakha/ter-grpc/extractor"
 	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"gitlab.com/gaydamakha/ter-grpc/sandbox"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	_ "google.golang.org/grpc/encoding/gzip"
 )
@@ -24,12 +30,58 @@ type WorkerServerGRPC struct {
 	port        int
 	certificate string
 	key         string
+	chunkSize   int
+	tmpDir      string
+	health      *health.Server
+	// Delay between two checks of the worker health
+	healthInterval time.Duration
+	// Server the worker registers with, if any
+	registerWith      string
+	registrationToken string
+	advertise         string
+	capacity          int
+	labels            map[string]string
+	heartbeatInterval time.Duration
+	// closed by Close to stop the heartbeats
+	stop chan struct{}
+	// time after which the extraction is stopped
+	maxProcessingTime time.Duration
+	sandbox           *sandbox.Sandbox
+	// extractors available on the worker, the default one first
+	extractors []extractor.Extractor
 }
 
 type WorkerServerGRPCConfig struct {
-	Certificate string
-	Key         string
-	Port        int
+	Certificate    string
+	Key            string
+	Port           int
+	ChunkSize      int
+	TmpDir         string
+	HealthInterval time.Duration
+	// Address of the server to register with, empty to be given to the server at its startup
+	RegisterWith string
+	// Token given to the server to register
+	RegistrationToken string
+	// Address the server can reach the worker at, the hostname and port by default
+	Advertise string
+	// Number of requests the worker can process at once
+	Capacity          int
+	Labels            map[string]string
+	HeartbeatInterval time.Duration
+	// Time after which the extraction is stopped, as a backstop to the deadline that comes
+	// with the request: it must be at least the maximum processing time of the server, whose
+	// deadline would be cut short otherwise
+	MaxProcessingTime time.Duration
+	// Limits of each extractor or pdfinfo process, 0 for none
+	CPULimit       time.Duration
+	MemoryLimit    int64
+	FileSizeLimit  int64
+	OpenFilesLimit int
+	// User the processes run as, by name or uid[:gid], empty to keep the one of the worker
+	RunAs string
+	// Extractors the worker may run, the first available one being the default,
+	// all the known ones by default. The ones missing on the host are left out.
+	Extractors []string
 }
 
 func NewWorkerServerGRPC(cfg WorkerServerGRPCConfig) (s WorkerServerGRPC, err error) {
@@ -45,9 +97,80 @@ func NewWorkerServerGRPC(cfg WorkerServerGRPCConfig) (s WorkerServerGRPC, err er
 		return
 	}
 
+	switch {
+	case cfg.ChunkSize == 0:
+		err = errors.Errorf("ChunkSize must be specified")
+		s.logger.Error().Err(err)
+		return
+	case cfg.ChunkSize > (1 << 22):
+		err = errors.Errorf("ChunkSize must be < than 4MB")
+		s.logger.Error().Err(err)
+		return
+	default:
+		s.chunkSize = cfg.ChunkSize
+	}
+
 	s.port = cfg.Port
 	s.certificate = cfg.Certificate
 	s.key = cfg.Key
+	s.health = health.NewServer()
+	s.healthInterval = cfg.HealthInterval
+	if s.healthInterval == 0 {
+		s.healthInterval = 5 * time.Second
+	}
+
+	s.registerWith = cfg.RegisterWith
+	s.registrationToken = cfg.RegistrationToken
+	s.advertise = cfg.Advertise
+	if s.advertise == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return s, errors.Wrapf(err,
+				"failed to get the hostname to advertise")
+		}
+		s.advertise = hostname + ":" + strconv.Itoa(s.port)
+	}
+	s.capacity = cfg.Capacity
+	if s.capacity == 0 {
+		s.capacity = 1
+	}
+	s.labels = cfg.Labels
+	s.heartbeatInterval = cfg.HeartbeatInterval
+	if s.heartbeatInterval == 0 {
+		s.heartbeatInterval = 5 * time.Second
+	}
+	s.stop = make(chan struct{})
+	s.maxProcessingTime = cfg.MaxProcessingTime
+	if s.maxProcessingTime == 0 {
+		s.maxProcessingTime = 10 * time.Minute
+	}
+
+	s.tmpDir = cfg.TmpDir
+	if s.tmpDir == "" {
+		s.tmpDir = "/tmp/pdftotext/worker/"
+	}
+	err = os.MkdirAll(s.tmpDir, 0777)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create temporary directory %s",
+			s.tmpDir)
+		return
+	}
+	s.sandbox, err = sandbox.New(s.tmpDir, cfg.CPULimit, cfg.MemoryLimit, cfg.FileSizeLimit, cfg.OpenFilesLimit, cfg.RunAs)
+	if err != nil {
+		return
+	}
+	s.logger.Info().Msg(fmt.Sprintf("extractors run with %s", s.sandbox.Limits()))
+
+	names := cfg.Extractors
+	if len(names) == 0 {
+		names = extractor.Names()
+	}
+	s.extractors, err = s.availableExtractors(names)
+	if err != nil {
+		return
+	}
+	s.logger.Info().Msg(fmt.Sprintf("extractors %v are available, %s by default", s.extractorNames(), s.extractors[0].Name()))
 
 	s.logger.Info().Msg("Worker server successfully configured...")
 
@@ -86,6 +209,11 @@ func (s *WorkerServerGRPC) Listen() (err error) {
 
 	s.server = grpc.NewServer(grpcOpts...)
 	messaging.RegisterPdftotextWorkerServer(s.server, s)
+	healthpb.RegisterHealthServer(s.server, s.health)
+	go s.watchHealth()
+	if s.registerWith != "" {
+		go s.register()
+	}
 
 	s.logger.Info().Msg("Serving...")
 
@@ -103,80 +231,224 @@ func (s *WorkerServerGRPC) Listen() (err error) {
 // interface which is responsible for receiving a stream of
 // chunks that form a complete file.
 func (s *WorkerServerGRPC) UploadPdfAndGetText(stream messaging.PdftotextWorker_UploadPdfAndGetTextServer) (err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
+
 	uuid := uuid.New().String()
-	fn := "pdftotext" + uuid + ".pdf"
 	s.logger.Info().Msg(fmt.Sprintf("%s: receiving the upload...", uuid))
 
-	file, err := messaging.ReceiveFile(stream, fn)
+	opts, err := messaging.ReceiveOptions(stream)
+	if err != nil {
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: invalid options", uuid))
+		return
+	}
+	e, err := s.extractor(opts)
 	if err != nil {
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: invalid options", uuid))
 		return
 	}
 
-	s.logger.Info().Msg(fmt.Sprintf("%s: upload received: processing the text", uuid))
-	txtfn := "pdftotext" + uuid + ".txt"
-	_, err = exec.Command("pdftotext", fn, txtfn).Output()
+	dir, cleanup, err := s.sandbox.JobDir()
 	if err != nil {
-		err = errors.Wrapf(err,
-			"pdftotext didn't worked")
-		s.logger.Error().Err(err)
 		return
 	}
+	fn := dir + "document.pdf"
+
+	//Be clean, whatever happens.
+	defer cleanup()
 
-	// open recently created file
-	txtfile, err := os.Open(txtfn)
+	file, err := messaging.ReceiveFile(stream, fn)
 	if err != nil {
-		err = errors.Wrapf(err,
-			"can't open result file")
-		s.logger.Error().Err(err)
 		return
 	}
+	file.Close()
 
-	// read the result content
-	text, err := ioutil.ReadAll(txtfile)
+	s.logger.Info().Msg(fmt.Sprintf("%s: upload received: processing the text with %s", uuid, e.Name()))
+	// The extraction stops once the job is cancelled, the stream is broken or the deadline is reached
+	ctx, cancel := s.processingContext(stream.Context())
+	defer cancel()
+	var buf bytes.Buffer
+	out := s.sandbox.Output(&buf)
+	err = e.Extract(ctx, s.sandbox.Runner(dir), fn, out, opts)
 	if err != nil {
-		err = errors.Wrapf(err,
-			"can't read from result file")
+		err = s.sandbox.OutputError(out, err)
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: processing failed", uuid))
 		return
 	}
+	text := buf.Bytes()
 
 	s.logger.Info().Msg(fmt.Sprintf("%s: file processed: sending the file", uuid))
 
 	// once the transmission finished, send the
 	// confirmation and the text if nothing went wrong
-	err = stream.SendAndClose(&messaging.TextAndStatus{
+	res := &messaging.TextAndStatus{
 		Message: "File received with success",
-		Text:    text,
 		Code:    messaging.StatusCode_Ok,
-	})
+	}
+	if opts.Output == messaging.OutputMode_OutputPages {
+		res.Pages = messaging.SplitPages(text, opts.FirstPage)
+	} else {
+		res.Text = text
+	}
+	res.TextSha256 = messaging.TextChecksum(res)
+	if opts.IncludeMetadata {
+		res.Metadata, err = s.pdfinfo(ctx, dir, fn)
+		if err != nil {
+			s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: processing failed", uuid))
+			return
+		}
+	}
+	err = stream.SendAndClose(res)
 	if err != nil {
 		err = errors.Wrapf(err,
 			"failed to send status code")
-		s.logger.Error().Err(err)
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: sending failed", uuid))
 		return
 	}
 
 	s.logger.Info().Msg(fmt.Sprintf("%s: file sent", uuid))
 
-	//Be clean.
+	return
+}
+
+// ExtractText implements the ExtractText method of the PdftotextWorker interface.
+// Once the whole file is received, the text is streamed back while the extractor produces it.
+func (s *WorkerServerGRPC) ExtractText(stream messaging.PdftotextWorker_ExtractTextServer) (err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
+
+	uuid := uuid.New().String()
+	s.logger.Info().Msg(fmt.Sprintf("%s: receiving the upload...", uuid))
+
+	opts, err := messaging.ReceiveOptions(stream)
+	if err != nil {
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: invalid options", uuid))
+		return
+	}
+	if opts.Output == messaging.OutputMode_OutputPages {
+		return messaging.NewError(messaging.ErrorKind_ErrorInvalidOptions,
+			"per-page output is only available with UploadPdfAndGetText")
+	}
+	e, err := s.extractor(opts)
+	if err != nil {
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: invalid options", uuid))
+		return
+	}
+
+	dir, cleanup, err := s.sandbox.JobDir()
+	if err != nil {
+		return
+	}
+	fn := dir + "document.pdf"
+
+	//Be clean, whatever happens.
+	defer cleanup()
+
+	file, err := messaging.ReceiveFile(stream, fn)
+	if err != nil {
+		return
+	}
 	file.Close()
-	if os.Remove(fn) != nil {
-		err = errors.Wrapf(err,
-			"failed to remove tmp pdf file")
-		s.logger.Error().Err(err)
+
+	s.logger.Info().Msg(fmt.Sprintf("%s: upload received: streaming the text of %s", uuid, e.Name()))
+	ctx, cancel := s.processingContext(stream.Context())
+	defer cancel()
+	pr, pw := io.Pipe()
+	out := s.sandbox.Output(pw)
+	extracted := make(chan error, 1)
+	go func() {
+		err := e.Extract(ctx, s.sandbox.Runner(dir), fn, out, opts)
+		// The text ends with the extraction, or with its failure
+		pw.CloseWithError(err)
+		extracted <- err
+	}()
+
+	err = messaging.SendText(stream, s.chunkSize, pr)
+	// An extraction still writing is stopped, the text can't be sent anymore
+	pr.CloseWithError(io.ErrClosedPipe)
+	cancel()
+	if xerr := <-extracted; xerr != nil && (err == nil || errors.Cause(err) == xerr) {
+		err = s.sandbox.OutputError(out, xerr)
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: processing failed", uuid))
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: streaming failed", uuid))
 		return
 	}
-	txtfile.Close()
-	if os.Remove(txtfn) != nil {
+
+	s.logger.Info().Msg(fmt.Sprintf("%s: text sent", uuid))
+
+	return
+}
+
+// GetMetadata implements the GetMetadata method of the PdftotextWorker interface.
+// It returns the document information given by pdfinfo.
+func (s *WorkerServerGRPC) GetMetadata(stream messaging.PdftotextWorker_GetMetadataServer) (err error) {
+	// Failures reach the client with their gRPC code
+	defer func() { err = messaging.StatusError(err) }()
+
+	uuid := uuid.New().String()
+	s.logger.Info().Msg(fmt.Sprintf("%s: receiving the upload...", uuid))
+
+	dir, cleanup, err := s.sandbox.JobDir()
+	if err != nil {
+		return
+	}
+	fn := dir + "document.pdf"
+
+	//Be clean, whatever happens.
+	defer cleanup()
+
+	file, err := messaging.ReceiveFile(stream, fn)
+	if err != nil {
+		return
+	}
+	file.Close()
+
+	ctx, cancel := s.processingContext(stream.Context())
+	defer cancel()
+	meta, err := s.pdfinfo(ctx, dir, fn)
+	if err != nil {
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: processing failed", uuid))
+		return
+	}
+
+	err = stream.SendAndClose(meta)
+	if err != nil {
 		err = errors.Wrapf(err,
-			"failed to remove tmp txt file")
-		s.logger.Error().Err(err)
+			"failed to send metadata")
+		s.logger.Error().Err(err).Msg(fmt.Sprintf("%s: sending failed", uuid))
 		return
 	}
 
+	s.logger.Info().Msg(fmt.Sprintf("%s: metadata sent", uuid))
+
 	return
 }
 
+// processingContext returns the context of the processing of a request made with the ctx
+// context: it ends with the stream or at the deadline the server passes down with the request.
+// The maximum processing time of the worker only bounds the requests without deadline, or
+// whose deadline is further.
+func (s *WorkerServerGRPC) processingContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.maxProcessingTime)
+}
+
+// pdfinfo runs pdfinfo on the fn file of the dir job directory and parses its output.
+func (s *WorkerServerGRPC) pdfinfo(ctx context.Context, dir string, fn string) (meta *messaging.PdfMetadata, err error) {
+	out, err := s.sandbox.Command(ctx, dir, "pdfinfo", messaging.PdfinfoArgs(fn)...).Output()
+	if err != nil {
+		err = s.sandbox.CommandError(ctx, "pdfinfo", err, nil)
+		return
+	}
+
+	return messaging.ParsePdfInfo(out)
+}
+
 func (s *WorkerServerGRPC) Close() {
+	close(s.stop)
+	s.health.Shutdown()
 	if s.server != nil {
 		s.server.Stop()
 	}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Name of the service reported by the health server
+const serviceName = "messaging.PdftotextWorker"
+
+// watchHealth periodically checks whether the worker can process files
+// and reports the result through the health server.
+func (s *WorkerServerGRPC) watchHealth() {
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		err := s.checkHealth()
+		if err != nil {
+			s.logger.Error().Err(err).Msg("worker is not able to serve")
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		s.health.SetServingStatus("", status)
+		s.health.SetServingStatus(serviceName, status)
+
+		time.Sleep(s.healthInterval)
+	}
+}
+
+// checkHealth returns an error if one of the extractors of the worker or pdfinfo,
+// which gives the metadata, can't run anymore or the temporary directory is not writable.
+func (s *WorkerServerGRPC) checkHealth() (err error) {
+	for _, e := range s.extractors {
+		err = e.Available()
+		if err != nil {
+			return
+		}
+	}
+	_, err = exec.LookPath("pdfinfo")
+	if err != nil {
+		return errors.Wrapf(err,
+			"pdfinfo is not found")
+	}
+
+	file, err := ioutil.TempFile(s.tmpDir, "health")
+	if err != nil {
+		return errors.Wrapf(err,
+			"temporary directory %s is not writable",
+			s.tmpDir)
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	return
+}
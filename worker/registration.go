@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	"gitlab.com/gaydamakha/ter-grpc/messaging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// register announces the worker to the server, then keeps it registered
+// with heartbeats. The worker registers again if the server has dropped it.
+func (s *WorkerServerGRPC) register() {
+	conn, err := s.dialServer()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("registration is disabled")
+		return
+	}
+	defer conn.Close()
+	admin := messaging.NewPdftotextAdminClient(conn)
+
+	var id string
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), s.heartbeatInterval)
+		ctx = messaging.WithRegistrationToken(ctx, s.registrationToken)
+		if id == "" {
+			id, err = s.registerOnce(ctx, admin)
+		} else {
+			err = s.heartbeat(ctx, admin, id)
+			if status.Code(errors.Cause(err)) == codes.NotFound {
+				s.logger.Warn().Msg("server has dropped the worker: registering again")
+				id = ""
+			}
+		}
+		cancel()
+		if err != nil {
+			s.logger.Error().Err(err).Msg(fmt.Sprintf("failed to reach server %s", s.registerWith))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(s.heartbeatInterval):
+		}
+	}
+}
+
+func (s *WorkerServerGRPC) dialServer() (conn *grpc.ClientConn, err error) {
+	grpcOpts := []grpc.DialOption{}
+	if s.certificate != "" {
+		grpcCreds, err := credentials.NewClientTLSFromFile(s.certificate, "localhost")
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to create grpc tls client via root-cert %s",
+				s.certificate)
+		}
+		grpcOpts = append(grpcOpts, grpc.WithTransportCredentials(grpcCreds))
+	} else {
+		grpcOpts = append(grpcOpts, grpc.WithInsecure())
+	}
+
+	conn, err = grpc.Dial(s.registerWith, grpcOpts...)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to start grpc connection with address %s",
+			s.registerWith)
+		return
+	}
+
+	return
+}
+
+func (s *WorkerServerGRPC) registerOnce(ctx context.Context, admin messaging.PdftotextAdminClient) (id string, err error) {
+	res, err := admin.RegisterWorker(ctx, &messaging.WorkerRegistration{
+		Address:    s.advertise,
+		Capacity:   int32(s.capacity),
+		Labels:     s.labels,
+		Extractors: s.extractorNames(),
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to register as %s",
+			s.advertise)
+		return
+	}
+
+	s.logger.Info().Msg(fmt.Sprintf("registered on server %s as %s", s.registerWith, s.advertise))
+
+	return res.Id, nil
+}
+
+func (s *WorkerServerGRPC) heartbeat(ctx context.Context, admin messaging.PdftotextAdminClient, id string) (err error) {
+	res, err := admin.Heartbeat(ctx, &messaging.WorkerId{
+		Id: id,
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to send heartbeat")
+		return
+	}
+
+	timeout, err := ptypes.Duration(res.Timeout)
+	if err == nil && timeout <= s.heartbeatInterval {
+		s.logger.Warn().Msg(fmt.Sprintf("heartbeat interval %s is too long for the server timeout %s",
+			s.heartbeatInterval, timeout))
+	}
+
+	return nil
+}